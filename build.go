@@ -1,13 +1,25 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"html"
 	"html/template"
+	"image"
+	_ "image/jpeg" // registers JPEG decoding for card backgrounds
+	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	texttemplate "text/template"
@@ -24,7 +36,14 @@ import (
 	"github.com/brandur/modulir/modules/mtemplate"
 	"github.com/brandur/modulir/modules/mtemplatemd"
 	"github.com/brandur/modulir/modules/mtoml"
+	"github.com/brandur/mutelight/modules/ucard"
 	"github.com/brandur/mutelight/modules/ucommon"
+	"github.com/brandur/mutelight/modules/ucompress"
+	"github.com/brandur/mutelight/modules/uhosting"
+	"github.com/brandur/mutelight/modules/umanifest"
+	"github.com/brandur/mutelight/modules/uredirect"
+	"github.com/brandur/mutelight/modules/usearch"
+	"github.com/brandur/mutelight/modules/uviews"
 )
 
 //////////////////////////////////////////////////////////////////////////////
@@ -42,7 +61,24 @@ import (
 // reparsing all the source material. In each case we try to only reparse the
 // sources if those source files actually changed.
 var (
-	articles []*Article
+	articles  []*Article
+	fragments []*Fragment
+	links     []*Link
+
+	// Standalone pages like "About". Unlike articles, these are all parsed
+	// up front (in phase 0) because any of them may appear in the site's
+	// navigation, which is rendered into every other page.
+	pages []*Page
+
+	// Background image for social cards along with a hash of its source so
+	// that cards can be rerendered when it changes.
+	cardBackground     image.Image
+	cardBackgroundHash string
+
+	// Hashes of the social cards currently in the target directory keyed by
+	// article slug. Used to skip cards whose contents haven't changed.
+	cardHashes   = make(map[string]string)
+	cardHashesMu sync.Mutex
 )
 
 // A function map of template helpers which is the combined version of the maps
@@ -56,13 +92,40 @@ var htmlTemplateFuncMap template.FuncMap = mtemplate.CombineFuncMaps(
 var textTemplateFuncMap texttemplate.FuncMap = mtemplate.HTMLFuncMapToText(htmlTemplateFuncMap)
 
 // List of common build dependencies, a change in any of which will trigger a
-// rebuild on everything: partial views, JavaScripts, and stylesheets. Even
+// rebuild on everything: stylesheets and pages that appear in navigation. Even
 // though some of those changes will false positives, these sources are
 // pervasive enough, and changes infrequent enough, that it's worth the
 // tradeoff. This variable is a global because so many render functions access
 // it.
 var universalSources []string
 
+// Dependency graph of views and layouts to the partials that they include as
+// of the latest build. See viewDependencies.
+var viewGraph uviews.Graph
+
+// Prefixes of the names of jobs that don't render anything shown in a page,
+// and which may run on a build where only stylesheets changed. See
+// reloadKind.
+var nonPageJobPrefixes = []string{
+	"compress: ",
+	"hosting target: ",
+	"manifest",
+	"modification times",
+}
+
+// Format that redirects were last written in, so that they're written again
+// if it changes. See renderRedirects.
+var renderedRedirectFormat string
+
+// Sources of stylesheets as of the latest build.
+var stylesheetSources []string
+
+// Matches HTML tags so that they can be stripped to produce plain text.
+var htmlTagRE = regexp.MustCompile(`<[^>]*>`)
+
+// Matches the first paragraph in a rendered HTML document.
+var firstParagraphRE = regexp.MustCompile(`(?s)<p>(.*?)</p>`)
+
 //////////////////////////////////////////////////////////////////////////////
 //
 //
@@ -96,6 +159,10 @@ func build(c *modulir.Context) []error {
 
 	c.Log.Debugf("Running build loop")
 
+	if err := uhosting.Validate(conf.HostingTarget); err != nil {
+		return []error{err}
+	}
+
 	// This is where we stored "versioned" assets like compiled JS and CSS.
 	// These assets have a release number that we can increment and by
 	// extension quickly invalidate.
@@ -103,36 +170,94 @@ func build(c *modulir.Context) []error {
 
 	// A set of source paths that rebuild everything when any one of them
 	// changes. These are dependencies that are included in more or less
-	// everything: common partial views, JavaScript sources, and stylesheet
-	// sources.
+	// everything: stylesheet sources and pages that appear in navigation.
+	// Partial views are tracked per page instead (see viewDependencies).
 	universalSources = nil
 
-	// Generate a list of partial views to add to universal sources.
+	// Parse views and layouts for the partials that each one includes so that
+	// a change to a partial only rebuilds pages that include it. It's cheap
+	// enough to do every build, which also picks up new includes.
 	{
-		sources, err := mfile.ReadDirCached(c, c.SourceDir+"/views",
-			&mfile.ReadDirOptions{ShowMeta: true})
+		var err error
+		viewGraph, err = uviews.Build(ucommon.LayoutsDir, ucommon.ViewsDir)
 		if err != nil {
 			return []error{err}
 		}
+	}
 
-		var partialViews []string
-		for _, source := range sources {
-			if strings.HasPrefix(filepath.Base(source), "_") {
-				partialViews = append(partialViews, source)
-			}
+	// Generate a set of stylesheet sources to add to universal sources.
+	//
+	// Pages only link to stylesheets at a path that doesn't change with their
+	// contents, so in development they're left out so that tweaking a
+	// stylesheet doesn't rebuild every page. The loop server swaps them into
+	// open pages in place instead (see reloadKind).
+	{
+		var err error
+		stylesheetSources, err = mfile.ReadDirCached(c, c.SourceDir+"/content/stylesheets",
+			&mfile.ReadDirOptions{ShowMeta: true})
+		if err != nil {
+			return []error{err}
 		}
 
-		universalSources = append(universalSources, partialViews...)
+		if conf.MutelightEnv != mutelightEnvDevelopment {
+			universalSources = append(universalSources, stylesheetSources...)
+		}
 	}
 
-	// Generate a set of stylesheet sources to add to universal sources.
+	// Parse pages, and add those that appear in navigation to universal
+	// sources because a change to one might change the navigation rendered
+	// into every other page.
+	var pagesChanged bool
+
 	{
-		stylesheetSources, err := mfile.ReadDirCached(c, c.SourceDir+"/content/stylesheets",
-			&mfile.ReadDirOptions{ShowMeta: true})
+		sources, err := mfile.ReadDirCached(c, c.SourceDir+"/content/pages", nil)
 		if err != nil {
 			return []error{err}
 		}
-		universalSources = append(universalSources, stylesheetSources...)
+
+		// Only pages whose sources changed are parsed again, but the list is
+		// rebuilt from sources so that pages which were removed drop out.
+		parsed := make(map[string]*Page, len(pages))
+		for _, page := range pages {
+			parsed[page.Source] = page
+		}
+
+		pages = nil
+		for _, source := range sources {
+			page, ok := parsed[source]
+			if !ok || c.Changed(source) {
+				page, err = parsePage(c, source)
+				if err != nil {
+					return []error{err}
+				}
+				pagesChanged = true
+			}
+			delete(parsed, source)
+			pages = append(pages, page)
+
+			if page.Nav {
+				universalSources = append(universalSources, source)
+			}
+		}
+
+		// Anything left was parsed in a previous build but its source has
+		// since been removed, so remove its output too.
+		for _, page := range parsed {
+			if err := removeOutput(path.Join(c.TargetDir, page.Slug)); err != nil {
+				return []error{err}
+			}
+			pagesChanged = true
+		}
+
+		sortPages(pages)
+
+		// Check before pages are rendered so that a conflict is reported as
+		// such rather than as an error writing to an existing directory.
+		// Articles may not have been parsed yet, so this is checked again
+		// once they have been.
+		if err := checkPageConflicts(); err != nil {
+			return []error{err}
+		}
 	}
 
 	//
@@ -152,9 +277,24 @@ func build(c *modulir.Context) []error {
 	// phase 1. Try to make sure that as few phases as necessary.
 	//
 
+	// The archive used to be rendered to a file at `archive`, but it's now a
+	// directory so that it can contain pages for each year and month. Remove
+	// a file left over from an old build so that the directory can be created.
+	{
+		archivePath := c.TargetDir + "/archive"
+		if info, err := os.Lstat(archivePath); err == nil && info.Mode().IsRegular() {
+			if err := os.Remove(archivePath); err != nil {
+				return []error{xerrors.Errorf("error removing file '%s': %w", archivePath, err)}
+			}
+		}
+	}
+
 	{
 		commonDirs := []string{
-			c.TargetDir + "/a",
+			c.TargetDir + "/archive",
+			c.TargetDir + "/assets/cards",
+			c.TargetDir + "/fragments",
+			conf.CacheDir + "/cards",
 			versionedAssetsDir,
 		}
 		for _, dir := range commonDirs {
@@ -176,6 +316,20 @@ func build(c *modulir.Context) []error {
 			{c.SourceDir + "/content/stylesheets", versionedAssetsDir + "/stylesheets"},
 		}
 		for _, link := range commonSymlinks {
+			// Symlinks point to absolute paths on the machine doing the
+			// build, so reproducible builds copy assets instead, as do
+			// builds for hosts that we don't deploy to with `make deploy`.
+			// Precompressed builds copy them too so that compressed
+			// siblings are written into the target directory rather than
+			// back into the source.
+			if conf.Reproducible || conf.Precompress ||
+				uhosting.CopiesAssets(conf.HostingTarget) {
+				if err := copyDir(c, link[0], link[1]); err != nil {
+					return []error{err}
+				}
+				continue
+			}
+
 			err := mfile.EnsureSymlink(c, link[0], link[1])
 			if err != nil {
 				return []error{nil}
@@ -191,7 +345,7 @@ func build(c *modulir.Context) []error {
 	var articlesMu sync.Mutex
 
 	{
-		sources, err := mfile.ReadDirCached(c, c.SourceDir+"/content/articles", nil)
+		sources, err := readContentSources(c, "articles", "drafts")
 		if err != nil {
 			return []error{err}
 		}
@@ -207,6 +361,77 @@ func build(c *modulir.Context) []error {
 		}
 	}
 
+	//
+	// Fragments
+	//
+
+	var fragmentsChanged bool
+	var fragmentsMu sync.Mutex
+
+	{
+		sources, err := readContentSources(c, "fragments", "fragments-drafts")
+		if err != nil {
+			return []error{err}
+		}
+
+		for _, s := range sources {
+			source := s
+
+			name := fmt.Sprintf("fragment: %s", filepath.Base(source))
+			c.AddJob(name, func() (bool, error) {
+				return renderFragment(c, source,
+					&fragments, &fragmentsChanged, &fragmentsMu)
+			})
+		}
+	}
+
+	//
+	// Links
+	//
+
+	var linksChanged bool
+	var linksMu sync.Mutex
+
+	{
+		sources, err := readContentSources(c, "links", "links-drafts")
+		if err != nil {
+			return []error{err}
+		}
+
+		for _, s := range sources {
+			source := s
+
+			name := fmt.Sprintf("link: %s", filepath.Base(source))
+			c.AddJob(name, func() (bool, error) {
+				return renderLink(c, source,
+					&links, &linksChanged, &linksMu)
+			})
+		}
+	}
+
+	//
+	// Pages
+	//
+
+	for _, p := range pages {
+		page := p
+
+		name := fmt.Sprintf("page: %s", page.Slug)
+		c.AddJob(name, func() (bool, error) {
+			return renderPage(c, page)
+		})
+	}
+
+	//
+	// Search
+	//
+
+	{
+		c.AddJob("search", func() (bool, error) {
+			return renderSearch(c)
+		})
+	}
+
 	//
 	// Robots.txt
 	//
@@ -233,6 +458,33 @@ func build(c *modulir.Context) []error {
 	// Various sorts for anything that might need it.
 	{
 		sortArticles(articles)
+		sortFragments(fragments)
+		sortLinks(links)
+	}
+
+	// Pages are rendered to top-level URLs just like articles are, so make
+	// sure that none of them collide now that all articles are parsed.
+	if err := checkPageConflicts(); err != nil {
+		return []error{err}
+	}
+
+	// Social cards
+	{
+		backgroundSource := c.SourceDir + "/content/images/back.jpg"
+		if c.Changed(backgroundSource) {
+			if err := loadCardBackground(backgroundSource); err != nil {
+				return []error{err}
+			}
+		}
+
+		for _, a := range articles {
+			article := a
+
+			name := fmt.Sprintf("card: %s", article.Slug)
+			c.AddJob(name, func() (bool, error) {
+				return renderArticleCard(c, article)
+			})
+		}
 	}
 
 	// Index
@@ -253,6 +505,13 @@ func build(c *modulir.Context) []error {
 		})
 	}
 
+	// Articles archives for each year and month
+	{
+		c.AddJob("articles archives (years and months)", func() (bool, error) {
+			return renderArticlesArchives(c, articles, articlesChanged)
+		})
+	}
+
 	// Articles feed
 	{
 		c.AddJob("articles feed", func() (bool, error) {
@@ -260,7 +519,182 @@ func build(c *modulir.Context) []error {
 		})
 	}
 
-	return nil
+	// Search index
+	{
+		c.AddJob("search index", func() (bool, error) {
+			return renderSearchIndex(c, articles, articlesChanged)
+		})
+	}
+
+	//
+	// Fragments
+	//
+
+	// Fragments index
+	{
+		c.AddJob("fragments index", func() (bool, error) {
+			return renderFragmentsIndex(c, fragments, fragmentsChanged)
+		})
+	}
+
+	// Fragments feed
+	{
+		c.AddJob("fragments feed", func() (bool, error) {
+			return renderFragmentsFeed(c, fragments, fragmentsChanged)
+		})
+	}
+
+	//
+	// Links
+	//
+
+	// Links index
+	{
+		c.AddJob("links index", func() (bool, error) {
+			return renderLinksIndex(c, links, linksChanged)
+		})
+	}
+
+	// Links feed
+	{
+		c.AddJob("links feed", func() (bool, error) {
+			return renderLinksFeed(c, links, linksChanged)
+		})
+	}
+
+	//
+	// 404
+	//
+
+	{
+		c.AddJob("404", func() (bool, error) {
+			return renderNotFound(c, articles, articlesChanged || pagesChanged)
+		})
+	}
+
+	//
+	//
+	// PHASE 3
+	//
+	//
+	//
+
+	// Stale redirect stubs are found by looking through the outputs of every
+	// other job, so they all need to have finished first.
+	if errors := c.Wait(); errors != nil {
+		c.Log.Errorf("Cancelling next phase due to build errors")
+		return errors
+	}
+
+	//
+	// Redirects
+	//
+
+	{
+		c.AddJob("redirects", func() (bool, error) {
+			return renderRedirects(c, articles, articlesChanged)
+		})
+	}
+
+	//
+	//
+	// PHASE 4
+	//
+	//
+	//
+
+	// Post-processing for hosting targets may rename outputs, so every other
+	// job needs to have finished first.
+	if errors := c.Wait(); errors != nil {
+		c.Log.Errorf("Cancelling next phase due to build errors")
+		return errors
+	}
+
+	//
+	// Hosting target
+	//
+
+	{
+		c.AddJob("hosting target: "+conf.HostingTarget, func() (bool, error) {
+			return renderHostingTarget(c)
+		})
+	}
+
+	//
+	//
+	// PHASE 5
+	//
+	//
+	//
+
+	// Compression works on the outputs of every other job, so they all need
+	// to have finished first.
+	if errors := c.Wait(); errors != nil {
+		c.Log.Errorf("Cancelling next phase due to build errors")
+		return errors
+	}
+
+	//
+	// Precompressed outputs
+	//
+
+	if conf.Precompress {
+		sources, err := ucompress.FindCompressible(c.TargetDir)
+		if err != nil {
+			return []error{err}
+		}
+
+		for _, s := range sources {
+			source := s
+
+			// The manifest is for local use and is never served.
+			if source == path.Join(c.TargetDir, umanifest.Filename) {
+				continue
+			}
+
+			name := fmt.Sprintf("compress: %s", strings.TrimPrefix(source, c.TargetDir+"/"))
+			c.AddJob(name, func() (bool, error) {
+				return ucompress.Compress(source)
+			})
+		}
+	}
+
+	//
+	// Manifest
+	//
+
+	{
+		c.AddJob("manifest", func() (bool, error) {
+			return renderManifest(c, versionedAssetsDir)
+		})
+	}
+
+	//
+	//
+	// PHASE 6
+	//
+	//
+	//
+
+	if conf.Reproducible {
+		if errors := c.Wait(); errors != nil {
+			c.Log.Errorf("Cancelling next phase due to build errors")
+			return errors
+		}
+
+		//
+		// Modification times
+		//
+
+		c.AddJob("modification times", func() (bool, error) {
+			return true, stampModTimes(c.TargetDir, time.Unix(conf.SourceDateEpoch, 0))
+		})
+	}
+
+	// Wait on the final phase too so that the build is complete when this
+	// returns. Things done after a build, like telling pages in development
+	// that they can reload, depend on every job having finished.
+	return c.Wait()
 }
 
 //////////////////////////////////////////////////////////////////////////////
@@ -275,23 +709,48 @@ func build(c *modulir.Context) []error {
 
 // Article represents an article to be rendered.
 type Article struct {
+	// Aliases are old paths that the article was once addressable at, like
+	// from before it was renamed. Each one redirects to the article.
+	Aliases []string `toml:"aliases"`
+
 	// Content is the HTML content of the article. It isn't included as TOML
 	// frontmatter, and is rather split out of an article's Markdown file,
 	// rendered, and then added separately.
 	Content string `toml:"-"`
 
+	// Description is a short summary of the article written by hand. It's
+	// used as the article's meta description and Atom summary, and may be
+	// empty, in which case its excerpt is used instead.
+	Description string `toml:"description"`
+
+	// Excerpt is a short plain text summary of the article. It's the content
+	// that comes before a `<!--more-->` marker if the article has one, and its
+	// first paragraph otherwise.
+	Excerpt string `toml:"-"`
+
+	// HeroImage is an optional path or URL to an image that represents the
+	// article. It's used when the article is shared on social media.
+	HeroImage string `toml:"hero_image"`
+
 	// Location is the place where the article was published. It may be empty.
 	Location string `toml:"location"`
 
 	// PublishedAt is when the article was published.
 	PublishedAt *time.Time `toml:"published_at"`
 
+	// ReadingTime is a rough estimate of the number of minutes it'll take to
+	// read the article.
+	ReadingTime int `toml:"-"`
+
 	// Slug is a unique identifier for the article that also helps determine
 	// where it's addressable by URL.
 	Slug string `toml:"-"`
 
+	// Source is the path of the file that the article was parsed from.
+	Source string `toml:"-"`
+
 	// TinySlug is a short URL assigned to the article at `/a/<tiny slug>`
-	// which redirects to the main article.
+	// which redirects to the main article. See also Aliases.
 	//
 	// This was almost certainly something that was never needed, but I added
 	// it way back near 2010 when I was obsessed with URL shorteners, one of
@@ -300,6 +759,38 @@ type Article struct {
 
 	// Title is the article's title.
 	Title string `toml:"title"`
+
+	// UpdatedAt is when the article was last meaningfully updated. It may be
+	// nil if the article hasn't been updated since it was published.
+	UpdatedAt *time.Time `toml:"updated_at"`
+
+	// WordCount is the number of words in the article's content.
+	WordCount int `toml:"-"`
+}
+
+// lastUpdatedAt returns when the article was last updated, which is its
+// publish date if it's never been updated.
+func (a *Article) lastUpdatedAt() time.Time {
+	if a.UpdatedAt != nil {
+		return *a.UpdatedAt
+	}
+
+	return *a.PublishedAt
+}
+
+func (a *Article) atomEntry() *matom.Entry {
+	return &matom.Entry{
+		Title:     a.Title,
+		Summary:   a.Description,
+		Content:   &matom.EntryContent{Content: a.Content, Type: "html"},
+		Published: *a.PublishedAt,
+		Updated:   a.lastUpdatedAt(),
+		Link:      &matom.Link{Href: absoluteURL(a.Slug)},
+		ID:        "tag:" + ucommon.AtomTag + "," + a.PublishedAt.Format("2006-01-02") + ":/" + a.Slug,
+
+		AuthorName: ucommon.AtomAuthorName,
+		AuthorURI:  conf.AbsoluteURL,
+	}
 }
 
 func (a *Article) validate(source string) error {
@@ -311,260 +802,1731 @@ func (a *Article) validate(source string) error {
 		return xerrors.Errorf("no publish date for article: %v", source)
 	}
 
+	if a.UpdatedAt != nil && a.UpdatedAt.Before(*a.PublishedAt) {
+		return xerrors.Errorf("update date before publish date for article: %v", source)
+	}
+
 	return nil
 }
 
-// articleYear holds a collection of articles grouped by year.
-type articleYear struct {
-	Year     int
-	Articles []*Article
+// jsonLDPerson is a schema.org Person for use in JSON-LD metadata.
+type jsonLDPerson struct {
+	Type string `json:"@type"`
+	Name string `json:"name"`
+	URL  string `json:"url"`
 }
 
+// jsonLDBlogPosting is a schema.org BlogPosting for use in JSON-LD metadata
+// on article pages.
+type jsonLDBlogPosting struct {
+	Context          string        `json:"@context"`
+	Type             string        `json:"@type"`
+	Headline         string        `json:"headline"`
+	Description      string        `json:"description,omitempty"`
+	URL              string        `json:"url"`
+	MainEntityOfPage string        `json:"mainEntityOfPage"`
+	Image            string        `json:"image,omitempty"`
+	DatePublished    string        `json:"datePublished"`
+	DateModified     string        `json:"dateModified"`
+	WordCount        int           `json:"wordCount,omitempty"`
+	Author           *jsonLDPerson `json:"author"`
+}
+
+// jsonLDWebSite is a schema.org WebSite for use in JSON-LD metadata on pages
+// that aren't articles.
+type jsonLDWebSite struct {
+	Context     string        `json:"@context"`
+	Type        string        `json:"@type"`
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	URL         string        `json:"url"`
+	Author      *jsonLDPerson `json:"author"`
+}
+
+// Fragment represents a short-form note to be rendered. Fragments are
+// lighter weight than articles: they don't need a title, and may point to an
+// external link that they're commenting on.
+type Fragment struct {
+	// Content is the HTML content of the fragment. Like with articles, it's
+	// split out of the fragment's Markdown file and rendered separately.
+	Content string `toml:"-"`
+
+	// Link is an optional external URL that the fragment is about.
+	Link string `toml:"link"`
+
+	// PublishedAt is when the fragment was published.
+	PublishedAt *time.Time `toml:"published_at"`
+
+	// Slug is a unique identifier for the fragment that also helps determine
+	// where it's addressable by URL.
+	Slug string `toml:"-"`
+
+	// Source is the path of the file that the fragment was parsed from.
+	Source string `toml:"-"`
+
+	// Title is the fragment's title. It's optional, and a title is derived
+	// from the fragment's content if it's not set.
+	Title string `toml:"title"`
+}
+
+// DisplayTitle gets a title for the fragment, which is its own title if it
+// has one, and the first few words of its content otherwise.
+func (f *Fragment) DisplayTitle() string {
+	if f.Title != "" {
+		return f.Title
+	}
+
+	const numWords = 8
+	words := strings.Fields(plainText(f.Content))
+	if len(words) > numWords {
+		return strings.Join(words[:numWords], " ") + "…"
+	}
+
+	return strings.Join(words, " ")
+}
+
+// URL gets the path at which the fragment is addressable.
+func (f *Fragment) URL() string {
+	return "/fragments/" + f.Slug
+}
+
+func (f *Fragment) atomEntry() *matom.Entry {
+	return &matom.Entry{
+		Title:     f.DisplayTitle(),
+		Content:   &matom.EntryContent{Content: f.Content, Type: "html"},
+		Published: *f.PublishedAt,
+		Updated:   f.lastUpdatedAt(),
+		Link:      &matom.Link{Href: absoluteURL(f.URL())},
+		ID:        "tag:" + ucommon.AtomTag + "," + f.PublishedAt.Format("2006-01-02") + ":" + f.URL(),
+
+		AuthorName: ucommon.AtomAuthorName,
+		AuthorURI:  conf.AbsoluteURL,
+	}
+}
+
+// lastUpdatedAt returns when the fragment was last updated. Fragments aren't
+// expected to be updated, so this is always its publish date.
+func (f *Fragment) lastUpdatedAt() time.Time {
+	return *f.PublishedAt
+}
+
+func (f *Fragment) validate(source string) error {
+	if f.PublishedAt == nil {
+		return xerrors.Errorf("no publish date for fragment: %v", source)
+	}
+
+	return nil
+}
+
+// Link represents a link post: commentary on a page on some other site.
+type Link struct {
+	// Content is the HTML of the commentary on the link. Like with articles,
+	// it's split out of the link's Markdown file and rendered separately.
+	Content string `toml:"-"`
+
+	// PublishedAt is when the link was published.
+	PublishedAt *time.Time `toml:"published_at"`
+
+	// Slug is a unique identifier for the link. Links don't have pages of
+	// their own, so it's used as an anchor on the links page.
+	Slug string `toml:"-"`
+
+	// Source is the path of the file that the link was parsed from.
+	Source string `toml:"-"`
+
+	// Title is the title of the link, which is usually the title of the page
+	// being linked to.
+	Title string `toml:"title"`
+
+	// URL is the external URL being linked to.
+	URL string `toml:"url"`
+}
+
+// Permalink gets the path at which the link is addressable on this site.
+func (l *Link) Permalink() string {
+	return "/links#" + l.Slug
+}
+
+// atomEntry produces a feed entry whose main link points to the external
+// URL. The entry's related link points back to the link's permalink.
+func (l *Link) atomEntry() *matom.Entry {
+	return &matom.Entry{
+		Title:     l.Title,
+		Content:   &matom.EntryContent{Content: l.Content, Type: "html"},
+		Published: *l.PublishedAt,
+		Updated:   l.lastUpdatedAt(),
+		Link:      &matom.Link{Rel: "alternate", Type: "text/html", Href: l.URL},
+		ID:        "tag:" + ucommon.AtomTag + "," + l.PublishedAt.Format("2006-01-02") + ":/links/" + l.Slug,
+
+		AuthorName: ucommon.AtomAuthorName,
+		AuthorURI:  conf.AbsoluteURL,
+	}
+}
+
+// lastUpdatedAt returns when the link was last updated. Links aren't
+// expected to be updated, so this is always its publish date.
+func (l *Link) lastUpdatedAt() time.Time {
+	return *l.PublishedAt
+}
+
+func (l *Link) relatedLink() *matom.Link {
+	return &matom.Link{Rel: "related", Type: "text/html", Href: absoluteURL(l.Permalink())}
+}
+
+func (l *Link) validate(source string) error {
+	if l.Title == "" {
+		return xerrors.Errorf("no title for link: %v", source)
+	}
+
+	if l.URL == "" {
+		return xerrors.Errorf("no URL for link: %v", source)
+	}
+
+	if l.PublishedAt == nil {
+		return xerrors.Errorf("no publish date for link: %v", source)
+	}
+
+	return nil
+}
+
+// Page represents a standalone page like "About" to be rendered to a
+// top-level URL. Pages are excluded from feeds and article indexes.
+type Page struct {
+	// Content is the HTML content of the page. Like with articles, it's split
+	// out of the page's Markdown file and rendered separately.
+	Content string `toml:"-"`
+
+	// Description is an optional summary of the page used as its meta
+	// description.
+	Description string `toml:"description"`
+
+	// Nav is whether the page should be linked from the site's navigation.
+	Nav bool `toml:"nav"`
+
+	// NavOrder determines where the page appears in navigation relative to
+	// other pages. Lower numbers come first.
+	NavOrder int `toml:"nav_order"`
+
+	// NavTitle is an optional shorter title for the page to use in
+	// navigation.
+	NavTitle string `toml:"nav_title"`
+
+	// Slug is a unique identifier for the page that also determines where
+	// it's addressable by URL.
+	Slug string `toml:"-"`
+
+	// Source is the path of the file that the page was parsed from.
+	Source string `toml:"-"`
+
+	// Title is the page's title.
+	Title string `toml:"title"`
+}
+
+// NavLabel gets the text used to link to the page from navigation.
+func (p *Page) NavLabel() string {
+	if p.NavTitle != "" {
+		return p.NavTitle
+	}
+
+	return p.Title
+}
+
+func (p *Page) validate(source string) error {
+	if p.Title == "" {
+		return xerrors.Errorf("no title for page: %v", source)
+	}
+
+	return nil
+}
+
+// atomEntry wraps matom.Entry so that an entry can have more than one link,
+// which matom doesn't support. The Links field shadows the embedded Link
+// field when encoding.
+type atomEntry struct {
+	*matom.Entry
+
+	Links []*matom.Link `xml:"link"`
+}
+
+// atomFeed wraps matom.Feed so that it can contain atomEntry entries. The
+// Entries field shadows the embedded Entries field when encoding.
+type atomFeed struct {
+	*matom.Feed
+
+	Entries []*atomEntry `xml:"entry"`
+}
+
+// encode writes the feed as XML to w in the same way as matom.Feed.Encode.
+func (f *atomFeed) encode(w io.Writer, indent string) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return xerrors.Errorf("error writing feed: %w", err)
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", indent)
+	if err := encoder.Encode(f); err != nil {
+		return xerrors.Errorf("error encoding feed: %w", err)
+	}
+
+	return nil
+}
+
+// feedEntry is content that can be included in an Atom feed, like an article
+// or fragment.
+type feedEntry interface {
+	atomEntry() *matom.Entry
+	lastUpdatedAt() time.Time
+}
+
+// relatedFeedEntry is a feedEntry which has a link to a related page in
+// addition to its main link, like a link post whose main link points to an
+// external site.
+type relatedFeedEntry interface {
+	feedEntry
+	relatedLink() *matom.Link
+}
+
+// notFoundCandidate is a page that the 404 page may suggest to a visitor who
+// ended up there.
+type notFoundCandidate struct {
+	// Slug is the path of the page without a leading slash. The 404 page
+	// suggests candidates whose slugs are closest to the path requested.
+	Slug string `json:"slug"`
+
+	// Title is the title of the page.
+	Title string `json:"title"`
+}
+
+// articleMonth holds a collection of articles grouped by month.
+type articleMonth struct {
+	Year     int
+	Month    time.Month
+	Articles []*Article
+}
+
+// Title gets a human-readable name for the month like "March 2011".
+func (m *articleMonth) Title() string {
+	return fmt.Sprintf("%s %d", m.Month, m.Year)
+}
+
+// URL gets the URL of the month's archive page.
+func (m *articleMonth) URL() string {
+	return fmt.Sprintf("/archive/%d/%02d", m.Year, int(m.Month))
+}
+
+// articlePeriod is a link to the archive page for a year or month, used to
+// navigate between them.
+type articlePeriod struct {
+	Title string
+	URL   string
+}
+
+// articleYear holds a collection of articles grouped by year.
+type articleYear struct {
+	Year     int
+	Articles []*Article
+}
+
+// URL gets the URL of the year's archive page.
+func (y *articleYear) URL() string {
+	return fmt.Sprintf("/archive/%d", y.Year)
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Private
+//
+//
+//
 //////////////////////////////////////////////////////////////////////////////
-//
-//
-//
-// Private
-//
-//
-//
-//////////////////////////////////////////////////////////////////////////////
 
-// getAceOptions gets a good set of default options for Ace template rendering
-// for the project.
-func getAceOptions(dynamicReload bool) *ace.Options {
-	options := &ace.Options{FuncMap: htmlTemplateFuncMap}
+const (
+	// moreMarker is a marker that can be placed in an article's Markdown to
+	// indicate that everything before it should be used as its excerpt.
+	moreMarker = "<!--more-->"
+
+	// searchIndexPath is the path in the target directory of the search
+	// index.
+	searchIndexPath = "/assets/search.json"
+
+	// wordsPerMinute is the reading speed assumed when estimating how long an
+	// article will take to read.
+	wordsPerMinute = 200
+)
+
+// absoluteURL produces an absolute URL for the given site path. Paths that
+// are already absolute URLs are returned unchanged.
+func absoluteURL(p string) string {
+	if strings.HasPrefix(p, "http://") || strings.HasPrefix(p, "https://") {
+		return p
+	}
+
+	return strings.TrimSuffix(conf.AbsoluteURL, "/") + "/" + strings.TrimPrefix(p, "/")
+}
+
+// articleRedirects gets redirects to articles from their tiny slugs and any
+// old paths they may have had.
+func articleRedirects(articles []*Article) []*uredirect.Redirect {
+	var redirects []*uredirect.Redirect
+
+	for _, article := range articles {
+		to := "/" + article.Slug
+
+		if article.TinySlug != "" {
+			redirects = append(redirects, &uredirect.Redirect{
+				From: "/a/" + article.TinySlug,
+				To:   to,
+			})
+		}
+
+		for _, alias := range article.Aliases {
+			redirects = append(redirects, &uredirect.Redirect{
+				From: uredirect.NormalizePath(alias),
+				To:   to,
+			})
+		}
+	}
+
+	return redirects
+}
+
+// articleCardPath is the path in the target directory of an article's social
+// card image.
+func articleCardPath(article *Article) string {
+	return "/assets/cards/" + article.Slug + ".png"
+}
+
+// checkPageConflicts makes sure that no page is rendered to the same path as
+// an article or any other output.
+func checkPageConflicts() error {
+	for _, page := range pages {
+		self := "page: " + page.Slug
+		if output := conflictingOutput("/"+page.Slug, self); output != "" {
+			return xerrors.Errorf("page '%s' conflicts with %s", page.Slug, output)
+		}
+	}
+	return nil
+}
+
+// conflictingOutput describes the output that the build renders at path p,
+// like `article: my-article`, or returns an empty string if it renders
+// nothing there. An output described exactly as ignore is skipped so that
+// something can be checked against every output but itself. Outputs are
+// determined from content rather than by looking in the target directory so
+// that a redirect can take over a path where content was once rendered, like
+// the old slug of a renamed article.
+func conflictingOutput(p, ignore string) string {
+	for _, article := range articles {
+		if p == "/"+article.Slug {
+			return "article: " + article.Slug
+		}
+	}
+
+	for _, fragment := range fragments {
+		if p == fragment.URL() {
+			return "fragment: " + fragment.Slug
+		}
+	}
+
+	for _, page := range pages {
+		if p == "/"+page.Slug && "page: "+page.Slug != ignore {
+			return "page: " + page.Slug
+		}
+	}
+
+	for _, output := range []string{
+		"/404.html",
+		"/articles.atom",
+		"/fragments.atom",
+		"/index.html",
+		"/links",
+		"/links.atom",
+		"/robots.txt",
+		"/search",
+	} {
+		if p == output {
+			return "output: " + output
+		}
+	}
+
+	// Directories that the build renders into.
+	for _, dir := range []string{"/archive", "/assets", "/fragments", "/page"} {
+		if p == dir || strings.HasPrefix(p, dir+"/") {
+			return "directory: " + dir
+		}
+	}
+
+	if uhosting.IsConfigFile(p) {
+		return "configuration file: " + path.Base(p)
+	}
+
+	return ""
+}
+
+// copyDir mirrors the directory at source to target, copying files that
+// changed since the last build and removing ones that no longer exist in
+// source, along with their compressed siblings. If target is a symlink, like
+// one left from a build that wasn't reproducible, it's replaced with a
+// directory so that files aren't copied back over the source.
+func copyDir(c *modulir.Context, source, target string) error {
+	if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(target); err != nil {
+			return xerrors.Errorf("error removing symlink '%s': %w", target, err)
+		}
+	}
+
+	err := filepath.Walk(source, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(source, p)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if err := os.MkdirAll(filepath.Join(target, rel), 0o755); err != nil {
+				return xerrors.Errorf("error creating directory '%s': %w", filepath.Join(target, rel), err)
+			}
+			return nil
+		}
+
+		if !c.Changed(p) && fileExists(filepath.Join(target, rel)) {
+			return nil
+		}
+
+		return copyFile(p, filepath.Join(target, rel))
+	})
+	if err != nil {
+		return err
+	}
+
+	return filepath.Walk(target, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(target, p)
+		if err != nil {
+			return err
+		}
+
+		if fileExists(filepath.Join(source, rel)) {
+			return nil
+		}
+
+		// Compressed siblings are kept for as long as their originals are.
+		if ext := filepath.Ext(rel); ext == ucompress.BrotliExt || ext == ucompress.GzipExt {
+			if fileExists(filepath.Join(source, strings.TrimSuffix(rel, ext))) {
+				return nil
+			}
+		}
+
+		if err := os.RemoveAll(p); err != nil {
+			return xerrors.Errorf("error removing '%s': %w", p, err)
+		}
+
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// copyFile copies the file at source to target, replacing target if it
+// already exists.
+func copyFile(source, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return xerrors.Errorf("error opening file '%s': %w", source, err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(target)
+	if err != nil {
+		return xerrors.Errorf("error creating file '%s': %w", target, err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return xerrors.Errorf("error copying '%s' to '%s': %w", source, target, err)
+	}
+
+	return nil
+}
+
+// extractExcerpt produces a plain text excerpt for an article. The content
+// before a `<!--more-->` marker is used if there is one, and the first
+// paragraph of its rendered content otherwise.
+func extractExcerpt(data, content string) (string, error) {
+	if i := strings.Index(data, moreMarker); i != -1 {
+		excerpt, err := mmarkdownext.Render(data[:i], &mmarkdownext.RenderOptions{NoRetina: true})
+		if err != nil {
+			return "", err
+		}
+		return plainText(excerpt), nil
+	}
+
+	matches := firstParagraphRE.FindStringSubmatch(content)
+	if matches == nil {
+		return "", nil
+	}
+
+	return plainText(matches[1]), nil
+}
+
+// getAceOptions gets a good set of default options for Ace template rendering
+// for the project.
+func getAceOptions(dynamicReload bool) *ace.Options {
+	options := &ace.Options{FuncMap: htmlTemplateFuncMap}
+
+	if dynamicReload {
+		options.DynamicReload = true
+	}
+
+	return options
+}
+
+// Gets a map of local values for use while rendering a template and includes
+// a few "special" values that are globally relevant to all templates.
+//
+// Metadata for OpenGraph, Twitter cards, and JSON-LD defaults to values for
+// the site as a whole, but is derived from an article instead if one is
+// included in locals.
+func getLocals(title string, locals map[string]interface{}) map[string]interface{} {
+	defaults := map[string]interface{}{
+		"CanonicalURL":      absoluteURL("/"),
+		"GoogleAnalyticsID": conf.GoogleAnalyticsID,
+		"JSONLD":            template.HTML(""),
+		"MetaDescription":   "",
+		"ModifiedTime":      "",
+		"MutelightEnv":      conf.MutelightEnv,
+		"NavPages":          navPages(pages),
+		"OGImage":           absoluteURL(ucommon.DefaultImage),
+		"OGType":            "website",
+		"PublishedTime":     "",
+		"Release":           Release,
+		"SiteName":          ucommon.SiteName,
+		"Title":             title,
+		"TitleSuffix":       ucommon.TitleSuffix,
+		"TwitterCard":       "summary",
+		"TwitterHandle":     ucommon.TwitterHandle,
+	}
+
+	for k, v := range locals {
+		defaults[k] = v
+	}
+
+	if article, ok := defaults["Article"].(*Article); ok {
+		// Fall back to an article's own description, or failing that its
+		// excerpt, as its meta description.
+		if defaults["MetaDescription"] == "" {
+			if article.Description != "" {
+				defaults["MetaDescription"] = article.Description
+			} else {
+				defaults["MetaDescription"] = article.Excerpt
+			}
+		}
+
+		url := absoluteURL(article.Slug)
+		defaults["CanonicalURL"] = url
+		defaults["ModifiedTime"] = article.lastUpdatedAt().Format(time.RFC3339)
+		defaults["OGType"] = "article"
+		defaults["PublishedTime"] = article.PublishedAt.Format(time.RFC3339)
+
+		// Prefer an article's hero image, but otherwise use the social card
+		// that's generated for every article.
+		imageURL := absoluteURL(articleCardPath(article))
+		if article.HeroImage != "" {
+			imageURL = absoluteURL(article.HeroImage)
+		}
+		defaults["OGImage"] = imageURL
+		defaults["TwitterCard"] = "summary_large_image"
+
+		defaults["JSONLD"] = jsonLDScript(&jsonLDBlogPosting{
+			Context:          "https://schema.org",
+			Type:             "BlogPosting",
+			Headline:         article.Title,
+			Description:      defaults["MetaDescription"].(string),
+			URL:              url,
+			MainEntityOfPage: url,
+			Image:            imageURL,
+			DatePublished:    defaults["PublishedTime"].(string),
+			DateModified:     defaults["ModifiedTime"].(string),
+			WordCount:        article.WordCount,
+			Author:           jsonLDAuthor(),
+		})
+	}
+
+	if defaults["MetaDescription"] == "" {
+		defaults["MetaDescription"] = ucommon.SiteDescription
+	}
+
+	if defaults["JSONLD"] == template.HTML("") {
+		defaults["JSONLD"] = jsonLDScript(&jsonLDWebSite{
+			Context:     "https://schema.org",
+			Type:        "WebSite",
+			Name:        ucommon.SiteName,
+			Description: defaults["MetaDescription"].(string),
+			URL:         defaults["CanonicalURL"].(string),
+			Author:      jsonLDAuthor(),
+		})
+	}
+
+	return defaults
+}
+
+// fileExists returns whether a file exists at the given path.
+func fileExists(target string) bool {
+	_, err := os.Stat(target)
+	return err == nil
+}
+
+func groupArticlesByMonth(articles []*Article) []*articleMonth {
+	var month *articleMonth
+	var months []*articleMonth
+
+	for _, article := range articles {
+		if month == nil ||
+			month.Year != article.PublishedAt.Year() ||
+			month.Month != article.PublishedAt.Month() {
+			month = &articleMonth{article.PublishedAt.Year(), article.PublishedAt.Month(), nil}
+			months = append(months, month)
+		}
+
+		month.Articles = append(month.Articles, article)
+	}
+
+	return months
+}
+
+func groupArticlesByYear(articles []*Article) []*articleYear {
+	var year *articleYear
+	var years []*articleYear
+
+	for _, article := range articles {
+		if year == nil || year.Year != article.PublishedAt.Year() {
+			year = &articleYear{article.PublishedAt.Year(), nil}
+			years = append(years, year)
+		}
+
+		year.Articles = append(year.Articles, article)
+	}
+
+	return years
+}
+
+func insertOrReplaceFragment(fragments *[]*Fragment, fragment *Fragment) {
+	for i, f := range *fragments {
+		if fragment.Slug == f.Slug {
+			(*fragments)[i] = fragment
+			return
+		}
+	}
+
+	*fragments = append(*fragments, fragment)
+}
+
+func insertOrReplaceLink(links *[]*Link, link *Link) {
+	for i, l := range *links {
+		if link.Slug == l.Slug {
+			(*links)[i] = link
+			return
+		}
+	}
+
+	*links = append(*links, link)
+}
+
+// jsonLDAuthor returns the site's author for use in JSON-LD metadata.
+func jsonLDAuthor() *jsonLDPerson {
+	return &jsonLDPerson{
+		Type: "Person",
+		Name: ucommon.AtomAuthorName,
+		URL:  "https://brandur.org",
+	}
+}
+
+// jsonLDScript encodes the given value as JSON-LD and wraps it in a script
+// tag that can be included in a page's head. The JSON encoder escapes
+// characters like `<` and `>`, so the result is safe to include verbatim.
+func jsonLDScript(v interface{}) template.HTML {
+	data, err := json.Marshal(v)
+	if err != nil {
+		// Only ever called with simple structs made up of strings, so this
+		// should never happen.
+		panic(err)
+	}
+
+	return template.HTML(`<script type="application/ld+json">` + string(data) + `</script>`) //nolint:gosec
+}
+
+// indexPageURL gets the URL of the given page of the paginated home page.
+// Pages are numbered from one.
+func indexPageURL(page int) string {
+	if page <= 1 {
+		return "/"
+	}
+
+	return fmt.Sprintf("/page/%d", page)
+}
+
+func insertOrReplaceArticle(articles *[]*Article, article *Article) {
+	for i, a := range *articles {
+		if article.Slug == a.Slug {
+			(*articles)[i] = article
+			return
+		}
+	}
+
+	*articles = append(*articles, article)
+}
+
+// loadCardBackground decodes the background image used for social cards. The
+// background is optional, so it's not an error for it not to exist.
+func loadCardBackground(source string) error {
+	data, err := ioutil.ReadFile(source)
+	if os.IsNotExist(err) {
+		cardBackground = nil
+		cardBackgroundHash = ""
+		return nil
+	}
+	if err != nil {
+		return xerrors.Errorf("error reading file '%s': %w", source, err)
+	}
+
+	background, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return xerrors.Errorf("error decoding image '%s': %w", source, err)
+	}
+
+	sum := sha256.Sum256(data)
+	cardBackground = background
+	cardBackgroundHash = hex.EncodeToString(sum[:])
+
+	return nil
+}
+
+// manifestSources produces a function that gets the sources that produced an
+// output for the build manifest. Outputs built from a single piece of
+// content map back to its source file, while those built from every piece
+// of a type of content, like indexes and feeds, map to its directory.
+func manifestSources(c *modulir.Context, versionedAssetsDir string) func(p string) []string {
+	contentDir := func(dir string) []string {
+		return []string{ucommon.CleanPath(path.Join(c.SourceDir, "content", dir))}
+	}
+
+	sources := make(map[string][]string)
+
+	for _, article := range articles {
+		articleSources := []string{ucommon.CleanPath(article.Source)}
+		sources["/"+article.Slug] = articleSources
+		sources[articleCardPath(article)] = articleSources
+
+		for _, redirect := range articleRedirects([]*Article{article}) {
+			sources[redirect.From] = articleSources
+		}
+	}
+
+	for _, fragment := range fragments {
+		sources[fragment.URL()] = []string{ucommon.CleanPath(fragment.Source)}
+	}
+
+	for _, page := range pages {
+		sources["/"+page.Slug] = []string{ucommon.CleanPath(page.Source)}
+	}
+
+	sources["/404.html"] = append(contentDir("articles"), contentDir("pages")...)
+	sources["/archive/index.html"] = contentDir("articles")
+	sources["/articles.atom"] = contentDir("articles")
+	sources["/fragments.atom"] = contentDir("fragments")
+	sources["/fragments/index.html"] = contentDir("fragments")
+	sources["/index.html"] = contentDir("articles")
+	sources["/links"] = contentDir("links")
+	sources["/links.atom"] = contentDir("links")
+	sources[searchIndexPath] = contentDir("articles")
+
+	// Assets are symlinked in from the source directory, so their sources
+	// are the files that they link to.
+	assetsPath := "/" + strings.TrimPrefix(strings.TrimPrefix(versionedAssetsDir, c.TargetDir), "/")
+	symlinkedDirs := [][2]string{
+		{"/assets/images/", "images"},
+		{assetsPath + "/javascripts/", "javascripts"},
+		{assetsPath + "/stylesheets/", "stylesheets"},
+	}
+
+	// Paginated indexes and archive pages are built from all articles.
+	articlesPrefixes := []string{"/archive/", "/page/"}
+
+	return func(p string) []string {
+		if s, ok := sources[p]; ok {
+			return s
+		}
+
+		// Some hosting targets give pages an `.html` extension.
+		if s, ok := sources[strings.TrimSuffix(p, ".html")]; ok {
+			return s
+		}
+
+		for _, dir := range symlinkedDirs {
+			if strings.HasPrefix(p, dir[0]) {
+				return []string{ucommon.CleanPath(
+					path.Join(c.SourceDir, "content", dir[1], strings.TrimPrefix(p, dir[0])))}
+			}
+		}
+
+		for _, prefix := range articlesPrefixes {
+			if strings.HasPrefix(p, prefix) {
+				return contentDir("articles")
+			}
+		}
+
+		return nil
+	}
+}
+
+// navPages filters pages down to only those that appear in navigation.
+func navPages(pages []*Page) []*Page {
+	var nav []*Page
+	for _, page := range pages {
+		if page.Nav {
+			nav = append(nav, page)
+		}
+	}
+	return nav
+}
+
+// notFoundCandidates gets pages that the 404 page may suggest: every article
+// and page, along with articles' old aliases so that a mistyped old URL can
+// still be matched.
+func notFoundCandidates(articles []*Article, pages []*Page) []*notFoundCandidate {
+	var candidates []*notFoundCandidate
+
+	for _, article := range articles {
+		candidates = append(candidates, &notFoundCandidate{Slug: article.Slug, Title: article.Title})
+
+		for _, alias := range article.Aliases {
+			candidates = append(candidates, &notFoundCandidate{
+				Slug:  strings.TrimPrefix(uredirect.NormalizePath(alias), "/"),
+				Title: article.Title,
+			})
+		}
+	}
+
+	for _, page := range pages {
+		candidates = append(candidates, &notFoundCandidate{Slug: page.Slug, Title: page.Title})
+	}
+
+	return candidates
+}
+
+// paginateArticles splits articles into pages of perPage articles each.
+// There's always at least one page, even if there are no articles.
+func paginateArticles(articles []*Article, perPage int) [][]*Article {
+	if perPage < 1 {
+		return [][]*Article{articles}
+	}
+
+	pages := [][]*Article{}
+	for start := 0; start < len(articles); start += perPage {
+		end := start + perPage
+		if end > len(articles) {
+			end = len(articles)
+		}
+		pages = append(pages, articles[start:end])
+	}
+
+	if len(pages) < 1 {
+		pages = append(pages, nil)
+	}
+
+	return pages
+}
+
+// parseArticle parses an article's TOML frontmatter and renders its Markdown
+// content, along with everything derived from it like its excerpt.
+func parseArticle(c *modulir.Context, source string) (*Article, error) {
+	var article Article
+	data, err := mtoml.ParseFileFrontmatter(c, source, &article)
+	if err != nil {
+		return nil, err
+	}
+
+	err = article.validate(source)
+	if err != nil {
+		return nil, err
+	}
+
+	article.Slug = ucommon.ExtractSlug(source)
+	article.Source = source
+
+	content, err := mmarkdownext.Render(string(data), &mmarkdownext.RenderOptions{NoRetina: true})
+	if err != nil {
+		return nil, err
+	}
+	article.Content = content
+
+	article.Excerpt, err = extractExcerpt(string(data), content)
+	if err != nil {
+		return nil, err
+	}
+
+	article.WordCount = len(strings.Fields(plainText(content)))
+	article.ReadingTime = readingTime(article.WordCount)
+
+	return &article, nil
+}
+
+// parseFragment parses a fragment's TOML frontmatter and renders its Markdown
+// content.
+func parseFragment(c *modulir.Context, source string) (*Fragment, error) {
+	var fragment Fragment
+	data, err := mtoml.ParseFileFrontmatter(c, source, &fragment)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fragment.validate(source)
+	if err != nil {
+		return nil, err
+	}
+
+	fragment.Slug = ucommon.ExtractSlug(source)
+	fragment.Source = source
+
+	content, err := mmarkdownext.Render(string(data), &mmarkdownext.RenderOptions{NoRetina: true})
+	if err != nil {
+		return nil, err
+	}
+	fragment.Content = content
+
+	return &fragment, nil
+}
+
+// parseLink parses a link's TOML frontmatter and renders its Markdown
+// commentary.
+func parseLink(c *modulir.Context, source string) (*Link, error) {
+	var link Link
+	data, err := mtoml.ParseFileFrontmatter(c, source, &link)
+	if err != nil {
+		return nil, err
+	}
+
+	err = link.validate(source)
+	if err != nil {
+		return nil, err
+	}
+
+	link.Slug = ucommon.ExtractSlug(source)
+	link.Source = source
+
+	content, err := mmarkdownext.Render(string(data), &mmarkdownext.RenderOptions{NoRetina: true})
+	if err != nil {
+		return nil, err
+	}
+	link.Content = content
+
+	return &link, nil
+}
+
+// parsePage parses a page's TOML frontmatter and renders its Markdown
+// content.
+func parsePage(c *modulir.Context, source string) (*Page, error) {
+	var page Page
+	data, err := mtoml.ParseFileFrontmatter(c, source, &page)
+	if err != nil {
+		return nil, err
+	}
+
+	err = page.validate(source)
+	if err != nil {
+		return nil, err
+	}
+
+	page.Slug = ucommon.ExtractSlug(source)
+	page.Source = source
+
+	content, err := mmarkdownext.Render(string(data), &mmarkdownext.RenderOptions{NoRetina: true})
+	if err != nil {
+		return nil, err
+	}
+	page.Content = content
+
+	return &page, nil
+}
+
+// plainText strips tags out of the given HTML and collapses whitespace to
+// produce a plain text version of it suitable for use in places like meta
+// descriptions.
+func plainText(s string) string {
+	s = htmlTagRE.ReplaceAllString(s, "")
+	return strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+}
+
+// readContentSources lists the sources in a content directory like
+// `content/articles`. If drafts are enabled, sources in its corresponding
+// drafts directory are included as well, so that drafts go through exactly
+// the same rendering, sorting, and feed paths as published content.
+//
+// A content directory that doesn't exist yet is treated as empty.
+func readContentSources(c *modulir.Context, dir, draftsDir string) ([]string, error) {
+	var sources []string
+
+	if fileExists(c.SourceDir + "/content/" + dir) {
+		var err error
+		sources, err = mfile.ReadDirCached(c, c.SourceDir+"/content/"+dir, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.Drafts && fileExists(c.SourceDir+"/content/"+draftsDir) {
+		drafts, err := mfile.ReadDirCached(c, c.SourceDir+"/content/"+draftsDir, nil)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, drafts...)
+	}
+
+	return sources, nil
+}
+
+// readingTime estimates the number of minutes it'll take to read the given
+// number of words. It's never less than one minute.
+func readingTime(wordCount int) int {
+	return int(math.Max(1, math.Ceil(float64(wordCount)/wordsPerMinute)))
+}
+
+// redirectFormat gets the format that redirects are written in, which may be
+// determined by the hosting target.
+func redirectFormat() string {
+	return uhosting.RedirectFormat(conf.HostingTarget, conf.RedirectFormat)
+}
+
+// removeOutput removes the output at filename along with any variants of it
+// produced after rendering: the `.html` copy given to it by some hosting
+// targets, and the compressed siblings of either one. It's not an error if
+// none of them exist.
+func removeOutput(filename string) error {
+	for _, p := range []string{filename, filename + ".html"} {
+		for _, ext := range []string{"", ucompress.BrotliExt, ucompress.GzipExt} {
+			if err := os.Remove(p + ext); err != nil && !os.IsNotExist(err) {
+				return xerrors.Errorf("error removing output '%s': %w", p+ext, err)
+			}
+		}
+	}
+	return nil
+}
+
+// removeStaleIndexPages removes paginated index pages in dir, like
+// `page/3`, which are numbered higher than numPages.
+func removeStaleIndexPages(dir string, numPages int) error {
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return xerrors.Errorf("error reading directory '%s': %w", dir, err)
+	}
+
+	for _, info := range infos {
+		// Strip any extensions like `.html` or `.gz` to get the page number.
+		name := strings.SplitN(info.Name(), ".", 2)[0]
+
+		page, err := strconv.Atoi(name)
+		if err != nil || page <= numPages {
+			continue
+		}
+
+		if err := removeOutput(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func renderArticle(c *modulir.Context, source string,
+	articles *[]*Article, articlesChanged *bool, mu *sync.Mutex) (bool, error) {
+	sourceChanged := c.Changed(source)
+	viewsChanged := c.ChangedAny(viewDependencies(ucommon.MainLayout, ucommon.ViewsDir+"/articles/show.ace")...)
+	if !sourceChanged && !viewsChanged {
+		return false, nil
+	}
+
+	article, err := parseArticle(c, source)
+	if err != nil {
+		return true, err
+	}
+
+	locals := getLocals(article.Title, map[string]interface{}{
+		"Article": article,
+	})
+
+	// Always use force context because if we made it to here we know that our
+	// sources have changed.
+	err = mace.RenderFile(c, ucommon.MainLayout, ucommon.ViewsDir+"/articles/show.ace",
+		path.Join(c.TargetDir, article.Slug), getAceOptions(viewsChanged), locals)
+	if err != nil {
+		return true, err
+	}
+
+	mu.Lock()
+	insertOrReplaceArticle(articles, article)
+	*articlesChanged = true
+	mu.Unlock()
+
+	return true, nil
+}
+
+// renderArticleCard renders an article's social card image. Cards are cached
+// by a hash of their contents so that they only need to be rendered again
+// when something on them changes.
+func renderArticleCard(c *modulir.Context, article *Article) (bool, error) {
+	opts := &ucard.Options{
+		Background:     cardBackground,
+		BackgroundHash: cardBackgroundHash,
+		SiteName:       ucommon.AtomTag,
+		Subtitle:       article.PublishedAt.Format("January 2, 2006"),
+		Title:          article.Title,
+	}
+	hash := opts.Hash()
+
+	target := path.Join(c.TargetDir, articleCardPath(article))
+
+	cardHashesMu.Lock()
+	unchanged := cardHashes[article.Slug] == hash
+	cardHashesMu.Unlock()
+
+	if unchanged && fileExists(target) {
+		return false, nil
+	}
+
+	cached := path.Join(conf.CacheDir, "cards", hash+".png")
+	if !fileExists(cached) {
+		c.Log.Debugf("Rendering card: %s", article.Slug)
+
+		// Render to a temporary file first so that an interrupted build
+		// doesn't leave a partial card in the cache.
+		tempFile := cached + ".tmp"
+		f, err := os.Create(tempFile)
+		if err != nil {
+			return true, xerrors.Errorf("error creating file '%s': %w", tempFile, err)
+		}
+
+		err = ucard.Render(f, opts)
+		f.Close()
+		if err != nil {
+			return true, err
+		}
+
+		if err := os.Rename(tempFile, cached); err != nil {
+			return true, xerrors.Errorf("error renaming file '%s': %w", tempFile, err)
+		}
+	}
+
+	if err := copyFile(cached, target); err != nil {
+		return true, err
+	}
+
+	cardHashesMu.Lock()
+	cardHashes[article.Slug] = hash
+	cardHashesMu.Unlock()
+
+	return true, nil
+}
+
+func renderArticlesFeed(c *modulir.Context, articles []*Article, articlesChanged bool) (bool, error) {
+	if !articlesChanged {
+		return false, nil
+	}
+
+	entries := make([]feedEntry, len(articles))
+	for i, article := range articles {
+		entries[i] = article
+	}
+
+	return renderFeed(c, "articles", "Articles", "", entries)
+}
+
+func renderArticlesIndex(c *modulir.Context, articles []*Article, articlesChanged bool) (bool, error) {
+	viewsChanged := c.ChangedAny(viewDependencies(ucommon.MainLayout, ucommon.ViewsDir+"/articles/index.ace")...)
+	if !articlesChanged && !viewsChanged {
+		return false, nil
+	}
+
+	articlesByYear := groupArticlesByYear(articles)
+
+	locals := getLocals("Articles", map[string]interface{}{
+		"ArticlesByYear": articlesByYear,
+		"CanonicalURL":   absoluteURL("/archive"),
+	})
+
+	return true, mace.RenderFile(c, ucommon.MainLayout, ucommon.ViewsDir+"/articles/index.ace",
+		c.TargetDir+"/archive/index.html", getAceOptions(viewsChanged), locals)
+}
+
+// renderArticlesArchives renders archive pages for every year and month in
+// which articles were published, like `/archive/2011` and
+// `/archive/2011/03`. Each links to the periods immediately before and after
+// it.
+func renderArticlesArchives(c *modulir.Context, articles []*Article, articlesChanged bool) (bool, error) {
+	viewsChanged := c.ChangedAny(viewDependencies(ucommon.MainLayout, ucommon.ViewsDir+"/articles/period.ace")...)
+	if !articlesChanged && !viewsChanged {
+		return false, nil
+	}
+
+	// Renders a single period. Periods are ordered newest first, so the
+	// period at i-1 is newer and the one at i+1 is older.
+	renderPeriod := func(target, title string, months []*articleMonth, showMonthHeadings bool,
+		periods []*articlePeriod, i int) error {
+		var newer, older *articlePeriod
+		if i > 0 {
+			newer = periods[i-1]
+		}
+		if i < len(periods)-1 {
+			older = periods[i+1]
+		}
+
+		locals := getLocals("Articles from "+title, map[string]interface{}{
+			"ArticlesByMonth":   months,
+			"CanonicalURL":      absoluteURL(periods[i].URL),
+			"NewerPeriod":       newer,
+			"OlderPeriod":       older,
+			"PeriodTitle":       title,
+			"ShowMonthHeadings": showMonthHeadings,
+		})
+
+		return mace.RenderFile(c, ucommon.MainLayout, ucommon.ViewsDir+"/articles/period.ace",
+			target, getAceOptions(viewsChanged), locals)
+	}
+
+	years := groupArticlesByYear(articles)
+	yearPeriods := make([]*articlePeriod, len(years))
+	for i, year := range years {
+		yearPeriods[i] = &articlePeriod{Title: strconv.Itoa(year.Year), URL: year.URL()}
+	}
+
+	months := groupArticlesByMonth(articles)
+	monthPeriods := make([]*articlePeriod, len(months))
+	for i, month := range months {
+		monthPeriods[i] = &articlePeriod{Title: month.Title(), URL: month.URL()}
+	}
+
+	for i, year := range years {
+		err := mfile.EnsureDir(c, c.TargetDir+year.URL())
+		if err != nil {
+			return true, err
+		}
+
+		err = renderPeriod(c.TargetDir+year.URL()+"/index.html", yearPeriods[i].Title,
+			groupArticlesByMonth(year.Articles), true, yearPeriods, i)
+		if err != nil {
+			return true, err
+		}
+	}
 
-	if dynamicReload {
-		options.DynamicReload = true
+	for i, month := range months {
+		err := renderPeriod(c.TargetDir+month.URL(), monthPeriods[i].Title,
+			[]*articleMonth{month}, false, monthPeriods, i)
+		if err != nil {
+			return true, err
+		}
 	}
 
-	return options
+	return true, nil
 }
 
-// Gets a map of local values for use while rendering a template and includes
-// a few "special" values that are globally relevant to all templates.
-func getLocals(title string, locals map[string]interface{}) map[string]interface{} {
-	defaults := map[string]interface{}{
-		"GoogleAnalyticsID": conf.GoogleAnalyticsID,
-		"MetaDescription":   "",
-		"Release":           Release,
-		"MutelightEnv":      conf.MutelightEnv,
-		"Title":             title,
-		"TitleSuffix":       ucommon.TitleSuffix,
+// renderFeed renders an Atom feed from the given entries, which should be
+// ordered newest first. alternatePath is the page on the site that the feed
+// corresponds to.
+func renderFeed(c *modulir.Context, slug, title, alternatePath string, entries []feedEntry) (bool, error) {
+	filename := slug + ".atom"
+	title += ucommon.TitleSuffix
+
+	feed := &atomFeed{
+		Feed: &matom.Feed{
+			Title: title,
+			ID:    "tag:" + ucommon.AtomTag + ",2009:/" + slug,
+
+			Links: []*matom.Link{
+				{Rel: "self", Type: "application/atom+xml", Href: ucommon.AtomAbsoluteURL + "/" + filename},
+				{Rel: "alternate", Type: "text/html", Href: ucommon.AtomAbsoluteURL + alternatePath},
+			},
+		},
 	}
 
-	for k, v := range locals {
-		defaults[k] = v
+	// Optionally order the feed so that entries which were updated recently
+	// float to the top, which helps corrections to old articles reach readers.
+	// Copy the slice first so that the caller's ordering isn't disturbed.
+	if conf.AtomSortByUpdated {
+		entries = append([]feedEntry(nil), entries...)
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[j].lastUpdatedAt().Before(entries[i].lastUpdatedAt())
+		})
 	}
 
-	return defaults
-}
+	for i, entry := range entries {
+		if i >= conf.NumAtomEntries {
+			break
+		}
 
-func groupArticlesByYear(articles []*Article) []*articleYear {
-	var year *articleYear
-	var years []*articleYear
+		item := &atomEntry{Entry: entry.atomEntry()}
+		item.Links = []*matom.Link{item.Link}
+		if related, ok := entry.(relatedFeedEntry); ok {
+			item.Links = append(item.Links, related.relatedLink())
+		}
+		feed.Entries = append(feed.Entries, item)
 
-	for _, article := range articles {
-		if year == nil || year.Year != article.PublishedAt.Year() {
-			year = &articleYear{article.PublishedAt.Year(), nil}
-			years = append(years, year)
+		// The feed was updated whenever its most recently updated entry was.
+		if item.Updated.After(feed.Updated) {
+			feed.Updated = item.Updated
 		}
+	}
 
-		year.Articles = append(year.Articles, article)
+	filename = path.Join(c.TargetDir, filename)
+	f, err := os.Create(filename)
+	if err != nil {
+		return true, xerrors.Errorf("error creating file '%s': %w", filename, err)
 	}
+	defer f.Close()
 
-	return years
+	return true, feed.encode(f, "  ")
 }
 
-func insertOrReplaceArticle(articles *[]*Article, article *Article) {
-	for i, a := range *articles {
-		if article.Slug == a.Slug {
-			(*articles)[i] = article
-			return
+// renderHostingTarget post-processes the target directory for the
+// configured hosting target. See uhosting.
+func renderHostingTarget(c *modulir.Context) (bool, error) {
+	if conf.HostingTarget == uhosting.TargetS3 {
+		return false, nil
+	}
+
+	return true, uhosting.Apply(conf.HostingTarget, c.TargetDir, &uhosting.Options{
+		AbsoluteURL: conf.AbsoluteURL,
+		NginxRoot:   conf.NginxRoot,
+		Redirects:   articleRedirects(articles),
+	})
+}
+
+func renderIndex(c *modulir.Context, articles []*Article, articlesChanged bool) (bool, error) {
+	viewsChanged := c.ChangedAny(viewDependencies(ucommon.MainLayout, ucommon.ViewsDir+"/index.ace")...)
+	if !articlesChanged && !viewsChanged {
+		return false, nil
+	}
+
+	// The home page shows the most recent articles, and older ones are
+	// paginated out to `/page/2`, `/page/3`, etc.
+	pages := paginateArticles(articles, conf.NumIndexArticles)
+
+	if len(pages) > 1 {
+		if err := mfile.EnsureDir(c, c.TargetDir+"/page"); err != nil {
+			return true, err
 		}
 	}
 
-	*articles = append(*articles, article)
+	for i, pageArticles := range pages {
+		page := i + 1
+
+		title := "Mutelight"
+		if page > 1 {
+			title = fmt.Sprintf("Mutelight (page %d)", page)
+		}
+
+		var nextURL, prevURL string
+		if page > 1 {
+			prevURL = indexPageURL(page - 1)
+		}
+		if page < len(pages) {
+			nextURL = indexPageURL(page + 1)
+		}
+
+		locals := getLocals(title, map[string]interface{}{
+			"CanonicalURL": absoluteURL(indexPageURL(page)),
+			"NextURL":      nextURL,
+			"NumPages":     len(pages),
+			"Page":         page,
+			"PrevURL":      prevURL,
+			"TopArticles":  pageArticles,
+		})
+
+		target := c.TargetDir + "/index.html"
+		if page > 1 {
+			target = c.TargetDir + indexPageURL(page)
+		}
+
+		err := mace.RenderFile(c, ucommon.MainLayout, ucommon.ViewsDir+"/index.ace",
+			target, getAceOptions(viewsChanged), locals)
+		if err != nil {
+			return true, err
+		}
+	}
+
+	// There may be fewer pages than there were in a previous build, like if
+	// articles were removed, so get rid of any that aren't needed anymore.
+	if err := removeStaleIndexPages(c.TargetDir+"/page", len(pages)); err != nil {
+		return true, err
+	}
+
+	return true, nil
 }
 
-func renderArticle(c *modulir.Context, source string,
-	articles *[]*Article, articlesChanged *bool, mu *sync.Mutex) (bool, error) {
+func renderFragment(c *modulir.Context, source string,
+	fragments *[]*Fragment, fragmentsChanged *bool, mu *sync.Mutex) (bool, error) {
 	sourceChanged := c.Changed(source)
-	viewsChanged := c.ChangedAny(append(
-		[]string{
-			ucommon.MainLayout,
-			ucommon.ViewsDir + "/articles/show.ace",
-		},
-		universalSources...,
-	)...)
+	viewsChanged := c.ChangedAny(viewDependencies(ucommon.MainLayout, ucommon.ViewsDir+"/fragments/show.ace")...)
 	if !sourceChanged && !viewsChanged {
 		return false, nil
 	}
 
-	var article Article
-	data, err := mtoml.ParseFileFrontmatter(c, source, &article)
+	fragment, err := parseFragment(c, source)
 	if err != nil {
 		return true, err
 	}
 
-	err = article.validate(source)
+	locals := getLocals(fragment.DisplayTitle(), map[string]interface{}{
+		"CanonicalURL":    absoluteURL(fragment.URL()),
+		"Fragment":        fragment,
+		"MetaDescription": plainText(fragment.Content),
+	})
+
+	err = mace.RenderFile(c, ucommon.MainLayout, ucommon.ViewsDir+"/fragments/show.ace",
+		c.TargetDir+fragment.URL(), getAceOptions(viewsChanged), locals)
 	if err != nil {
 		return true, err
 	}
 
-	article.Slug = ucommon.ExtractSlug(source)
+	mu.Lock()
+	insertOrReplaceFragment(fragments, fragment)
+	*fragmentsChanged = true
+	mu.Unlock()
 
-	content, err := mmarkdownext.Render(string(data), &mmarkdownext.RenderOptions{NoRetina: true})
-	if err != nil {
-		return true, err
+	return true, nil
+}
+
+func renderFragmentsFeed(c *modulir.Context, fragments []*Fragment, fragmentsChanged bool) (bool, error) {
+	if !fragmentsChanged {
+		return false, nil
 	}
-	article.Content = content
 
-	locals := getLocals(article.Title, map[string]interface{}{
-		"Article": article,
+	entries := make([]feedEntry, len(fragments))
+	for i, fragment := range fragments {
+		entries[i] = fragment
+	}
+
+	return renderFeed(c, "fragments", "Fragments", "/fragments", entries)
+}
+
+func renderFragmentsIndex(c *modulir.Context, fragments []*Fragment, fragmentsChanged bool) (bool, error) {
+	viewsChanged := c.ChangedAny(viewDependencies(ucommon.MainLayout, ucommon.ViewsDir+"/fragments/index.ace")...)
+	if !fragmentsChanged && !viewsChanged {
+		return false, nil
+	}
+
+	locals := getLocals("Fragments", map[string]interface{}{
+		"CanonicalURL": absoluteURL("/fragments"),
+		"Fragments":    fragments,
 	})
 
-	// Always use force context because if we made it to here we know that our
-	// sources have changed.
-	err = mace.RenderFile(c, ucommon.MainLayout, ucommon.ViewsDir+"/articles/show.ace",
-		path.Join(c.TargetDir, article.Slug), getAceOptions(viewsChanged), locals)
-	if err != nil {
-		return true, err
+	return true, mace.RenderFile(c, ucommon.MainLayout, ucommon.ViewsDir+"/fragments/index.ace",
+		c.TargetDir+"/fragments/index.html", getAceOptions(viewsChanged), locals)
+}
+
+// renderLink parses a link. Links don't have pages of their own, so unlike
+// articles there's nothing to render until the links page is rendered in a
+// later phase.
+func renderLink(c *modulir.Context, source string,
+	links *[]*Link, linksChanged *bool, mu *sync.Mutex) (bool, error) {
+	if !c.Changed(source) {
+		return false, nil
 	}
 
-	// Ideally, this would be an actual redirect, but the combination of S3 +
-	// CloudFront makes those somewhat difficult. Also, these should never
-	// really get used from anywhere anymore so it doesn't actually matter that
-	// much.
-	if article.TinySlug != "" {
-		filename := path.Join(c.TargetDir, "a", article.TinySlug)
-		err := ioutil.WriteFile(
-			filename,
-			[]byte(fmt.Sprintf(
-				`<!DOCTYPE html><html>please click through to: <strong><a href="/%s">/%s</a></strong></html>`,
-				article.Slug, article.Slug,
-			)),
-			0o600,
-		)
-		if err != nil {
-			return true, xerrors.Errorf("error writing file '%s': %w", filename, err)
-		}
+	link, err := parseLink(c, source)
+	if err != nil {
+		return true, err
 	}
 
 	mu.Lock()
-	insertOrReplaceArticle(articles, &article)
-	*articlesChanged = true
+	insertOrReplaceLink(links, link)
+	*linksChanged = true
 	mu.Unlock()
 
 	return true, nil
 }
 
-func renderArticlesFeed(c *modulir.Context, articles []*Article, articlesChanged bool) (bool, error) {
-	if !articlesChanged {
+func renderLinksFeed(c *modulir.Context, links []*Link, linksChanged bool) (bool, error) {
+	if !linksChanged {
 		return false, nil
 	}
 
-	return renderFeed(c, "articles", "Articles", articles)
+	entries := make([]feedEntry, len(links))
+	for i, link := range links {
+		entries[i] = link
+	}
+
+	return renderFeed(c, "links", "Links", "/links", entries)
 }
 
-func renderArticlesIndex(c *modulir.Context, articles []*Article, articlesChanged bool) (bool, error) {
-	viewsChanged := c.ChangedAny(append(
-		[]string{
-			ucommon.MainLayout,
-			ucommon.ViewsDir + "/articles/index.ace",
-		},
-		universalSources...,
-	)...)
-	if !articlesChanged && !viewsChanged {
+func renderLinksIndex(c *modulir.Context, links []*Link, linksChanged bool) (bool, error) {
+	viewsChanged := c.ChangedAny(viewDependencies(ucommon.MainLayout, ucommon.ViewsDir+"/links/index.ace")...)
+	if !linksChanged && !viewsChanged {
 		return false, nil
 	}
 
-	articlesByYear := groupArticlesByYear(articles)
-
-	locals := getLocals("Articles", map[string]interface{}{
-		"ArticlesByYear": articlesByYear,
+	locals := getLocals("Links", map[string]interface{}{
+		"CanonicalURL": absoluteURL("/links"),
+		"Links":        links,
 	})
 
-	return true, mace.RenderFile(c, ucommon.MainLayout, ucommon.ViewsDir+"/articles/index.ace",
-		c.TargetDir+"/archive", getAceOptions(viewsChanged), locals)
+	return true, mace.RenderFile(c, ucommon.MainLayout, ucommon.ViewsDir+"/links/index.ace",
+		c.TargetDir+"/links", getAceOptions(viewsChanged), locals)
 }
 
-func renderFeed(_ *modulir.Context, slug, title string, articles []*Article) (bool, error) {
-	filename := slug + ".atom"
-	title += ucommon.TitleSuffix
-
-	feed := &matom.Feed{
-		Title: title,
-		ID:    "tag:" + ucommon.AtomTag + ",2009:/" + slug,
+// renderManifest writes a manifest of every output in the target directory
+// along with the sources that produced them. The manifest is only written if
+// it changed so that its modification time reflects the last build that
+// changed something.
+func renderManifest(c *modulir.Context, versionedAssetsDir string) (bool, error) {
+	manifest, err := umanifest.Build(c.TargetDir, manifestSources(c, versionedAssetsDir))
+	if err != nil {
+		return true, err
+	}
 
-		Links: []*matom.Link{
-			{Rel: "self", Type: "application/atom+xml", Href: ucommon.AtomAbsoluteURL + "/" + filename},
-			{Rel: "alternate", Type: "text/html", Href: ucommon.AtomAbsoluteURL},
-		},
+	var buf bytes.Buffer
+	if err := manifest.Write(&buf); err != nil {
+		return true, err
 	}
 
-	if len(articles) > 0 {
-		feed.Updated = *articles[0].PublishedAt
+	filename := path.Join(c.TargetDir, umanifest.Filename)
+
+	existing, err := ioutil.ReadFile(filename)
+	if err == nil && bytes.Equal(existing, buf.Bytes()) {
+		return false, nil
 	}
 
-	for i, article := range articles {
-		if i >= conf.NumAtomEntries {
-			break
-		}
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0o600); err != nil {
+		return true, xerrors.Errorf("error writing file '%s': %w", filename, err)
+	}
 
-		atomEntry := &matom.Entry{
-			Title:     article.Title,
-			Content:   &matom.EntryContent{Content: article.Content, Type: "html"},
-			Published: *article.PublishedAt,
-			Updated:   *article.PublishedAt,
-			Link:      &matom.Link{Href: conf.AbsoluteURL + article.Slug},
-			ID:        "tag:" + ucommon.AtomTag + "," + article.PublishedAt.Format("2006-01-02") + ":/" + article.Slug,
+	return true, nil
+}
 
-			AuthorName: ucommon.AtomAuthorName,
-			AuthorURI:  conf.AbsoluteURL,
-		}
-		feed.Entries = append(feed.Entries, atomEntry)
+// renderNotFound renders the site's 404 page. The page includes a list of
+// candidate pages built at build time, and suggests those whose paths are
+// closest to the one that wasn't found.
+func renderNotFound(c *modulir.Context, articles []*Article, candidatesChanged bool) (bool, error) {
+	viewsChanged := c.ChangedAny(viewDependencies(ucommon.MainLayout, ucommon.ViewsDir+"/404.ace")...)
+	if !candidatesChanged && !viewsChanged {
+		return false, nil
 	}
 
-	f, err := os.Create(path.Join(conf.TargetDir, filename))
+	slugsJSON, err := json.Marshal(notFoundCandidates(articles, pages))
 	if err != nil {
-		return true, xerrors.Errorf("error creating file '%s': %w", filename, err)
+		return true, xerrors.Errorf("error encoding 404 candidates: %w", err)
 	}
-	defer f.Close()
 
-	return true, feed.Encode(f, "  ")
+	locals := getLocals("Page not found", map[string]interface{}{
+		"SlugsJSON": string(slugsJSON),
+	})
+
+	return true, mace.RenderFile(c, ucommon.MainLayout, ucommon.ViewsDir+"/404.ace",
+		c.TargetDir+"/404.html", getAceOptions(viewsChanged), locals)
 }
 
-func renderIndex(c *modulir.Context, articles []*Article, articlesChanged bool) (bool, error) {
-	viewsChanged := c.ChangedAny(append(
-		[]string{
-			ucommon.MainLayout,
-			ucommon.ViewsDir + "/index.ace",
-		},
-		universalSources...,
-	)...)
-	if !articlesChanged && !viewsChanged {
+func renderPage(c *modulir.Context, page *Page) (bool, error) {
+	sourceChanged := c.Changed(page.Source)
+	viewsChanged := c.ChangedAny(viewDependencies(ucommon.MainLayout, ucommon.ViewsDir+"/pages/show.ace")...)
+	if !sourceChanged && !viewsChanged {
 		return false, nil
 	}
 
-	// Cut off the number of items on the main page at some point.
-	const numTopArticles = 10
-	var topArticles []*Article
-	for i := 0; i < numTopArticles; i++ {
-		if i >= len(articles) {
-			break
+	locals := getLocals(page.Title, map[string]interface{}{
+		"CanonicalURL":    absoluteURL(page.Slug),
+		"MetaDescription": page.Description,
+		"Page":            page,
+	})
+
+	return true, mace.RenderFile(c, ucommon.MainLayout, ucommon.ViewsDir+"/pages/show.ace",
+		path.Join(c.TargetDir, page.Slug), getAceOptions(viewsChanged), locals)
+}
+
+// renderRedirects writes redirects to articles in the format selected by
+// configuration. HTML stubs are written at each redirect's path for formats
+// that need them, and a redirects file for formats that use one. Stubs and
+// files that are no longer needed, like a stub for an alias that was removed
+// or the file of a different format, are removed.
+func renderRedirects(c *modulir.Context, articles []*Article, articlesChanged bool) (bool, error) {
+	format := redirectFormat()
+	if !articlesChanged && format == renderedRedirectFormat {
+		return false, nil
+	}
+
+	redirects := articleRedirects(articles)
+
+	err := uredirect.Validate(format, redirects)
+	if err != nil {
+		return true, err
+	}
+
+	// Make sure that a redirect never clobbers anything else that the build
+	// renders.
+	for _, redirect := range redirects {
+		if output := conflictingOutput(redirect.From, ""); output != "" {
+			return true, xerrors.Errorf("redirect from '%s' conflicts with %s", redirect.From, output)
 		}
+	}
 
-		topArticles = append(topArticles, articles[i])
+	var stubs []*uredirect.Redirect
+	if uredirect.UsesStubs(format) {
+		stubs = redirects
 	}
 
-	locals := getLocals("Mutelight", map[string]interface{}{
-		"TopArticles": topArticles,
-	})
+	if err := uredirect.RemoveStaleStubs(c.TargetDir, stubs); err != nil {
+		return true, err
+	}
+
+	for _, redirect := range stubs {
+		filename := path.Join(c.TargetDir, redirect.From)
+
+		err := os.MkdirAll(path.Dir(filename), 0o755)
+		if err != nil {
+			return true, xerrors.Errorf("error creating directory '%s': %w", path.Dir(filename), err)
+		}
+
+		err = ioutil.WriteFile(filename, uredirect.HTMLStub(redirect.To, absoluteURL(redirect.To)), 0o600)
+		if err != nil {
+			return true, xerrors.Errorf("error writing file '%s': %w", filename, err)
+		}
+	}
+
+	for _, other := range uredirect.Formats {
+		name := uredirect.Filename(other)
+		if name == "" || other == format {
+			continue
+		}
+
+		filename := path.Join(c.TargetDir, name)
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return true, xerrors.Errorf("error removing file '%s': %w", filename, err)
+		}
+	}
 
-	return true, mace.RenderFile(c, ucommon.MainLayout, ucommon.ViewsDir+"/index.ace",
-		c.TargetDir+"/index.html", getAceOptions(viewsChanged), locals)
+	if name := uredirect.Filename(format); name != "" {
+		var buf bytes.Buffer
+		if err := uredirect.Write(&buf, format, redirects); err != nil {
+			return true, err
+		}
+
+		filename := path.Join(c.TargetDir, name)
+		if err := ioutil.WriteFile(filename, buf.Bytes(), 0o600); err != nil {
+			return true, xerrors.Errorf("error writing file '%s': %w", filename, err)
+		}
+	}
+
+	renderedRedirectFormat = format
+	return true, nil
 }
 
 func renderRobotsTxt(c *modulir.Context) (bool, error) {
@@ -598,8 +2560,121 @@ Disallow: /
 	return true, nil
 }
 
+func renderSearch(c *modulir.Context) (bool, error) {
+	viewsChanged := c.ChangedAny(viewDependencies(ucommon.MainLayout, ucommon.ViewsDir+"/search.ace")...)
+	if !viewsChanged {
+		return false, nil
+	}
+
+	locals := getLocals("Search", map[string]interface{}{
+		"CanonicalURL":    absoluteURL("/search"),
+		"SearchIndexPath": searchIndexPath,
+	})
+
+	return true, mace.RenderFile(c, ucommon.MainLayout, ucommon.ViewsDir+"/search.ace",
+		c.TargetDir+"/search", getAceOptions(viewsChanged), locals)
+}
+
+// renderSearchIndex builds an inverted index over the text of every article
+// that's queried by the search page's script.
+func renderSearchIndex(c *modulir.Context, articles []*Article, articlesChanged bool) (bool, error) {
+	if !articlesChanged {
+		return false, nil
+	}
+
+	docs := make([]*usearch.Document, len(articles))
+	for i, article := range articles {
+		docs[i] = &usearch.Document{
+			PublishedAt: *article.PublishedAt,
+			Slug:        article.Slug,
+			Text:        plainText(article.Content),
+			Title:       article.Title,
+		}
+	}
+
+	data, err := json.Marshal(usearch.NewIndex(docs))
+	if err != nil {
+		return true, xerrors.Errorf("error encoding search index: %w", err)
+	}
+
+	filename := path.Join(c.TargetDir, searchIndexPath)
+	if err := ioutil.WriteFile(filename, data, 0o600); err != nil {
+		return true, xerrors.Errorf("error writing file '%s': %w", filename, err)
+	}
+
+	return true, nil
+}
+
 func sortArticles(articles []*Article) {
-	sort.Slice(articles, func(i, j int) bool {
-		return articles[j].PublishedAt.Before(*articles[i].PublishedAt)
+	sortNewestFirst(articles, func(i int) (time.Time, string) {
+		return *articles[i].PublishedAt, articles[i].Slug
+	})
+}
+
+func sortFragments(fragments []*Fragment) {
+	sortNewestFirst(fragments, func(i int) (time.Time, string) {
+		return *fragments[i].PublishedAt, fragments[i].Slug
+	})
+}
+
+func sortLinks(links []*Link) {
+	sortNewestFirst(links, func(i int) (time.Time, string) {
+		return *links[i].PublishedAt, links[i].Slug
+	})
+}
+
+// sortNewestFirst sorts a slice of published content, like articles or
+// fragments, so that the most recently published comes first. key gets the
+// publish date and slug of the element at the given index.
+//
+// Content is parsed concurrently, so the order of the slice going in varies
+// between builds. Ties are broken by slug so that the order coming out
+// doesn't.
+func sortNewestFirst(slice interface{}, key func(i int) (time.Time, string)) {
+	sort.Slice(slice, func(i, j int) bool {
+		publishedAtI, slugI := key(i)
+		publishedAtJ, slugJ := key(j)
+		if !publishedAtI.Equal(publishedAtJ) {
+			return publishedAtJ.Before(publishedAtI)
+		}
+		return slugI < slugJ
+	})
+}
+
+// sortPages sorts pages by their navigation order, falling back to their
+// slugs so that the order is stable.
+func sortPages(pages []*Page) {
+	sort.Slice(pages, func(i, j int) bool {
+		if pages[i].NavOrder != pages[j].NavOrder {
+			return pages[i].NavOrder < pages[j].NavOrder
+		}
+		return pages[i].Slug < pages[j].Slug
+	})
+}
+
+// stampModTimes gives every file and directory in dir the same modification
+// time so that archives of it are identical between builds.
+func stampModTimes(dir string, modTime time.Time) error {
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if err := os.Chtimes(p, modTime, modTime); err != nil {
+			return xerrors.Errorf("error setting times on '%s': %w", p, err)
+		}
+		return nil
 	})
 }
+
+// viewDependencies gets the sources that a page rendered with the given
+// layout and view depends on: both templates, every partial that either one
+// includes, and universal sources. Paths are normalized with
+// ucommon.CleanPath like the view graph's are.
+func viewDependencies(layout, view string) []string {
+	deps := viewGraph.Dependencies(layout, view)
+	for _, source := range universalSources {
+		deps = append(deps, ucommon.CleanPath(source))
+	}
+	return deps
+}
@@ -0,0 +1,962 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brandur/modulir"
+	"github.com/brandur/mutelight/internal/utesting"
+	"github.com/brandur/mutelight/modules/ucompress"
+	"github.com/brandur/mutelight/modules/umanifest"
+	"github.com/brandur/mutelight/modules/uredirect"
+)
+
+// Pass `-update` to rewrite golden files with the output of the current
+// build:
+//
+//	go test -run TestBuild -update .
+var update = flag.Bool("update", false, "update golden files")
+
+const (
+	// fixtureSourceDir is a small site that's built by tests.
+	fixtureSourceDir = "./testdata"
+
+	// goldenDir contains the expected output of building fixtureSourceDir.
+	goldenDir = "./testdata/golden"
+)
+
+func TestBuild(t *testing.T) {
+	if !*update && !fileExists(goldenDir) {
+		t.Fatalf("no golden files in '%s' (run `make golden` to create them)", goldenDir)
+	}
+
+	targetDir := newTestConf(t)
+
+	c := newTestContext(fixtureSourceDir, targetDir)
+	if errors := buildRound(c); len(errors) > 0 {
+		t.Fatalf("build failed: %v", errors)
+	}
+
+	outputs := listGoldenOutputs(t, targetDir)
+
+	// Make sure that all the kinds of output that we care about were
+	// produced so that golden files don't silently go missing.
+	for _, expected := range []string{
+		"404.html",
+		"a/1",
+		"a/2",
+		"about",
+		"archive/2011/03",
+		"archive/2011/index.html",
+		"archive/index.html",
+		"articles.atom",
+		"assets/search.json",
+		"first-article",
+		"fragments.atom",
+		"fragments/a-fragment",
+		"index.html",
+		"links",
+		"links.atom",
+		"manifest.json",
+		"old-second-article",
+		"robots.txt",
+		"search",
+	} {
+		if !containsString(outputs, expected) {
+			t.Errorf("expected build to produce '%s'", expected)
+		}
+	}
+
+	for _, output := range outputs {
+		actual, err := ioutil.ReadFile(filepath.Join(targetDir, output))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		golden := filepath.Join(goldenDir, output)
+
+		if *update {
+			if err := os.MkdirAll(filepath.Dir(golden), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(golden, actual, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		expected, err := ioutil.ReadFile(golden)
+		if os.IsNotExist(err) {
+			t.Errorf("no golden file for '%s' (run with -update to create it)", output)
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(expected, actual) {
+			t.Errorf("output '%s' doesn't match golden file (run with -update to update it)\n"+
+				"expected:\n%s\nactual:\n%s", output, expected, actual)
+		}
+	}
+}
+
+func TestBuildCopiedAssets(t *testing.T) {
+	sourceDir := copyFixture(t)
+	targetDir := newTestConf(t)
+	conf.Precompress = true
+
+	c := newTestContext(sourceDir, targetDir)
+	if errors := buildRound(c); len(errors) > 0 {
+		t.Fatalf("build failed: %v", errors)
+	}
+
+	assetsDir := "assets/" + Release
+
+	t.Run("Unchanged", func(t *testing.T) {
+		stampOutputs(t, targetDir)
+
+		if errors := buildRound(c); len(errors) > 0 {
+			t.Fatalf("build failed: %v", errors)
+		}
+
+		rewritten := rewrittenOutputs(t, targetDir)
+		for _, output := range []string{
+			assetsDir + "/javascripts/main.js",
+			assetsDir + "/stylesheets/main.css",
+		} {
+			if containsString(rewritten, output) {
+				t.Errorf("expected output '%s' not to be rewritten", output)
+			}
+		}
+	})
+
+	t.Run("ChangedAndRemoved", func(t *testing.T) {
+		stampOutputs(t, targetDir)
+		touchSource(t, sourceDir+"/content/stylesheets/main.css")
+		if err := os.Remove(sourceDir + "/content/javascripts/main.js"); err != nil {
+			t.Fatal(err)
+		}
+
+		if errors := buildRound(c); len(errors) > 0 {
+			t.Fatalf("build failed: %v", errors)
+		}
+
+		rewritten := rewrittenOutputs(t, targetDir)
+		if !containsString(rewritten, assetsDir+"/stylesheets/main.css") {
+			t.Errorf("expected output '%s' to be rewritten", assetsDir+"/stylesheets/main.css")
+		}
+		if fileExists(filepath.Join(targetDir, assetsDir, "javascripts/main.js")) {
+			t.Errorf("expected output '%s' to be removed", assetsDir+"/javascripts/main.js")
+		}
+	})
+}
+
+func TestBuildIncremental(t *testing.T) {
+	sourceDir := copyFixture(t)
+	targetDir := newTestConf(t)
+
+	c := newTestContext(sourceDir, targetDir)
+	if errors := buildRound(c); len(errors) > 0 {
+		t.Fatalf("build failed: %v", errors)
+	}
+
+	t.Run("Unchanged", func(t *testing.T) {
+		stampOutputs(t, targetDir)
+
+		if errors := buildRound(c); len(errors) > 0 {
+			t.Fatalf("build failed: %v", errors)
+		}
+
+		jobs := executedJobs(c)
+		for _, name := range []string{
+			"article: first-article.md",
+			"article: second-article.md",
+			"articles archives (years and months)",
+			"articles feed",
+			"articles index (Archive)",
+			"index",
+			"robots.txt",
+			"search index",
+		} {
+			if containsString(jobs, name) {
+				t.Errorf("expected job '%s' not to execute", name)
+			}
+		}
+
+		utesting.AssertEqual(t, []string(nil), rewrittenOutputs(t, targetDir))
+	})
+
+	t.Run("PageRemoved", func(t *testing.T) {
+		if err := os.Remove(sourceDir + "/content/pages/about.md"); err != nil {
+			t.Fatal(err)
+		}
+
+		if errors := buildRound(c); len(errors) > 0 {
+			t.Fatalf("build failed: %v", errors)
+		}
+
+		if fileExists(filepath.Join(targetDir, "about")) {
+			t.Errorf("expected output 'about' to be removed")
+		}
+
+		// The 404 page suggests pages, so it's rebuilt too.
+		if !containsString(executedJobs(c), "404") {
+			t.Errorf("expected job '404' to execute")
+		}
+	})
+
+	t.Run("ArticleChanged", func(t *testing.T) {
+		stampOutputs(t, targetDir)
+		touchSource(t, sourceDir+"/content/articles/first-article.md")
+
+		if errors := buildRound(c); len(errors) > 0 {
+			t.Fatalf("build failed: %v", errors)
+		}
+
+		// Only the changed article is rerendered, but it's enough to rebuild
+		// everything that lists articles.
+		jobs := executedJobs(c)
+		for _, name := range []string{
+			"article: first-article.md",
+			"articles archives (years and months)",
+			"articles feed",
+			"articles index (Archive)",
+			"index",
+			"search index",
+		} {
+			if !containsString(jobs, name) {
+				t.Errorf("expected job '%s' to execute", name)
+			}
+		}
+		for _, name := range []string{
+			"article: second-article.md",
+			"article: third-article.md",
+			"fragments feed",
+			"links feed",
+		} {
+			if containsString(jobs, name) {
+				t.Errorf("expected job '%s' not to execute", name)
+			}
+		}
+
+		rewritten := rewrittenOutputs(t, targetDir)
+		for _, output := range []string{
+			"archive/index.html",
+			"articles.atom",
+			"first-article",
+			"index.html",
+		} {
+			if !containsString(rewritten, output) {
+				t.Errorf("expected output '%s' to be rewritten", output)
+			}
+		}
+		for _, output := range []string{
+			"fragments.atom",
+			"second-article",
+			"third-article",
+		} {
+			if containsString(rewritten, output) {
+				t.Errorf("expected output '%s' not to be rewritten", output)
+			}
+		}
+	})
+}
+
+func TestBuildPrecompress(t *testing.T) {
+	sourceDir := copyFixture(t)
+	targetDir := newTestConf(t)
+	conf.Precompress = true
+
+	// Make the stylesheet large enough to be worth compressing.
+	stylesheet := filepath.Join(sourceDir, "content/stylesheets/main.css")
+	err := ioutil.WriteFile(stylesheet,
+		[]byte(strings.Repeat("body { margin: 0; }\n", 100)), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestContext(sourceDir, targetDir)
+	if errors := buildRound(c); len(errors) > 0 {
+		t.Fatalf("build failed: %v", errors)
+	}
+
+	// Assets are copied in so that their compressed siblings are written
+	// into the target directory rather than back into the source.
+	target := filepath.Join(targetDir, "assets", Release, "stylesheets/main.css")
+	for _, ext := range []string{ucompress.BrotliExt, ucompress.GzipExt} {
+		if !fileExists(target + ext) {
+			t.Errorf("expected '%s' to be produced", target+ext)
+		}
+		if fileExists(stylesheet + ext) {
+			t.Errorf("expected '%s' not to be written into the source", stylesheet+ext)
+		}
+	}
+}
+
+func TestBuildReproducible(t *testing.T) {
+	buildTree := func() string {
+		targetDir := newTestConf(t)
+		conf.Reproducible = true
+		conf.SourceDateEpoch = 1300000000
+
+		c := newTestContext(fixtureSourceDir, targetDir)
+		if errors := buildRound(c); len(errors) > 0 {
+			t.Fatalf("build failed: %v", errors)
+		}
+		return targetDir
+	}
+
+	targetDir1 := buildTree()
+	targetDir2 := buildTree()
+
+	tree1 := readTree(t, targetDir1)
+	tree2 := readTree(t, targetDir2)
+
+	for p, entry1 := range tree1 {
+		entry2, ok := tree2[p]
+		if !ok {
+			t.Errorf("'%s' only produced by first build", p)
+			continue
+		}
+		if entry1 != entry2 {
+			t.Errorf("'%s' differs between builds: %+v vs. %+v", p, entry1, entry2)
+		}
+	}
+	for p := range tree2 {
+		if _, ok := tree1[p]; !ok {
+			t.Errorf("'%s' only produced by second build", p)
+		}
+	}
+
+	// Assets should've been copied rather than symlinked.
+	for p, entry := range tree1 {
+		if entry.mode&os.ModeSymlink != 0 {
+			t.Errorf("expected '%s' not to be a symlink", p)
+		}
+	}
+}
+
+func TestCheckPageConflicts(t *testing.T) {
+	newTestConf(t)
+
+	articles = []*Article{{Slug: "first-article"}}
+
+	pages = []*Page{{Slug: "about"}}
+	if err := checkPageConflicts(); err != nil {
+		t.Errorf("expected no conflict, got %v", err)
+	}
+
+	for slug, expected := range map[string]string{
+		"archive":       "directory: /archive",
+		"first-article": "article: first-article",
+		"fragments":     "directory: /fragments",
+		"links":         "output: /links",
+		"page":          "directory: /page",
+		"search":        "output: /search",
+	} {
+		t.Run(slug, func(t *testing.T) {
+			pages = []*Page{{Slug: "about"}, {Slug: slug}}
+
+			err := checkPageConflicts()
+			if err == nil || !strings.Contains(err.Error(), expected) {
+				t.Errorf("expected error containing %q, got %v", expected, err)
+			}
+		})
+	}
+}
+
+func TestExtractExcerpt(t *testing.T) {
+	t.Run("FirstParagraph", func(t *testing.T) {
+		excerpt, err := extractExcerpt("",
+			"<p>First <em>paragraph</em>.</p>\n\n<p>Second paragraph.</p>")
+		if err != nil {
+			t.Fatal(err)
+		}
+		utesting.AssertEqual(t, "First paragraph.", excerpt)
+	})
+
+	t.Run("NoParagraph", func(t *testing.T) {
+		excerpt, err := extractExcerpt("", "<pre>code</pre>")
+		if err != nil {
+			t.Fatal(err)
+		}
+		utesting.AssertEqual(t, "", excerpt)
+	})
+}
+
+func TestGroupArticlesByYear(t *testing.T) {
+	a1 := &Article{Slug: "a1", PublishedAt: testTime(t, "2012-06-08T00:00:00Z")}
+	a2 := &Article{Slug: "a2", PublishedAt: testTime(t, "2012-01-01T00:00:00Z")}
+	a3 := &Article{Slug: "a3", PublishedAt: testTime(t, "2011-12-31T00:00:00Z")}
+
+	years := groupArticlesByYear([]*Article{a1, a2, a3})
+	if len(years) != 2 {
+		t.Fatalf("expected 2 years, got %v", len(years))
+	}
+
+	utesting.AssertEqual(t, 2012, years[0].Year)
+	utesting.AssertEqual(t, []*Article{a1, a2}, years[0].Articles)
+
+	utesting.AssertEqual(t, 2011, years[1].Year)
+	utesting.AssertEqual(t, []*Article{a3}, years[1].Articles)
+
+	utesting.AssertEqual(t, 0, len(groupArticlesByYear(nil)))
+}
+
+func TestInsertOrReplaceArticle(t *testing.T) {
+	var articles []*Article
+
+	a1 := &Article{Slug: "a1", Title: "Original"}
+	insertOrReplaceArticle(&articles, a1)
+	utesting.AssertEqual(t, []*Article{a1}, articles)
+
+	a2 := &Article{Slug: "a2"}
+	insertOrReplaceArticle(&articles, a2)
+	utesting.AssertEqual(t, []*Article{a1, a2}, articles)
+
+	// Same slug as an existing article replaces it in place.
+	a1Updated := &Article{Slug: "a1", Title: "Updated"}
+	insertOrReplaceArticle(&articles, a1Updated)
+	utesting.AssertEqual(t, []*Article{a1Updated, a2}, articles)
+}
+
+func TestListUploads(t *testing.T) {
+	targetDir := t.TempDir()
+
+	for file, data := range map[string]string{
+		"articles/index.html": "articles",
+		"assets/app.css":      "css",
+		"index.html":          "index",
+		"index.html.gz":       "index (compressed)",
+		"manifest.json":       "{}",
+		"old":                 "stub",
+		"s3-redirects.tsv":    "/old\t/new\n",
+	} {
+		utesting.WriteFile(t, filepath.Join(targetDir, filepath.FromSlash(file)), data)
+	}
+
+	// Compressed siblings are only used if they're up to date.
+	info, err := os.Stat(filepath.Join(targetDir, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(targetDir, "index.html.gz"),
+		info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	uploads, err := listUploads(targetDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	printUploads(&buf, uploads)
+	utesting.AssertEqual(t, strings.Join([]string{
+		"articles/index.html\tarticles/index.html\ttext/html\tpublic, max-age=3600\t-\t-",
+		"articles\tarticles/index.html\ttext/html\tpublic, max-age=3600\t-\t-",
+		"assets/app.css\tassets/app.css\ttext/css\tpublic, max-age=86400\t-\t-",
+		"index.html\tindex.html.gz\ttext/html\tpublic, max-age=3600\tgzip\t-",
+		"old\told\ttext/html\tpublic, max-age=3600\t-\t/new",
+		"",
+	}, "\n"), buf.String())
+
+	t.Run("Since", func(t *testing.T) {
+		deployed, err := umanifest.Build(targetDir, func(string) []string { return nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ioutil.WriteFile(filepath.Join(targetDir, "assets/app.css"), []byte("new css"), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Only outputs that changed since the deploy are uploaded.
+		uploads, err := listUploads(targetDir, deployed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		printUploads(&buf, uploads)
+		utesting.AssertEqual(t, "assets/app.css\tassets/app.css\ttext/css\tpublic, max-age=86400\t-\t-\n",
+			buf.String())
+	})
+}
+
+func TestPlainText(t *testing.T) {
+	utesting.AssertEqual(t, "Hello & goodbye world.",
+		plainText("<p>Hello &amp; <strong>goodbye</strong>\n  world.</p>"))
+}
+
+func TestReadingTime(t *testing.T) {
+	utesting.AssertEqual(t, 1, readingTime(0))
+	utesting.AssertEqual(t, 1, readingTime(wordsPerMinute))
+	utesting.AssertEqual(t, 2, readingTime(wordsPerMinute+1))
+}
+
+func TestRemoveStaleIndexPages(t *testing.T) {
+	dir := t.TempDir()
+
+	files := []string{
+		"2", "2.gz",
+		"3", "3.br", "3.gz",
+		"4.html", "4.html.gz",
+		"not-a-page",
+	}
+	for _, name := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := removeStaleIndexPages(dir, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	var remaining []string
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range infos {
+		remaining = append(remaining, info.Name())
+	}
+	utesting.AssertEqual(t, []string{"2", "2.gz", "not-a-page"}, remaining)
+
+	// A directory that doesn't exist has no pages to remove.
+	if err := removeStaleIndexPages(filepath.Join(dir, "missing"), 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRenderArticleCard(t *testing.T) {
+	targetDir := newTestConf(t)
+	c := newTestContext(fixtureSourceDir, targetDir)
+
+	article := &Article{
+		PublishedAt: testTime(t, "2011-03-14T00:00:00Z"),
+		Slug:        "first-article",
+		Title:       "First Article",
+	}
+	target := filepath.Join(targetDir, articleCardPath(article))
+
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(conf.CacheDir, "cards"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	assertRendered := func(expected bool) {
+		t.Helper()
+
+		executed, err := renderArticleCard(c, article)
+		if err != nil {
+			t.Fatal(err)
+		}
+		utesting.AssertEqual(t, expected, executed)
+
+		if !fileExists(target) {
+			t.Errorf("expected card at '%s'", target)
+		}
+	}
+
+	assertRendered(true)
+	assertRendered(false)
+
+	// Cards are rendered again when their contents change, and each version
+	// is cached separately.
+	article.Title = "First Article, Renamed"
+	assertRendered(true)
+
+	cached, err := filepath.Glob(filepath.Join(conf.CacheDir, "cards", "*.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utesting.AssertEqual(t, 2, len(cached))
+
+	// A card that's missing from the target is restored from the cache.
+	if err := os.Remove(target); err != nil {
+		t.Fatal(err)
+	}
+	assertRendered(true)
+}
+
+func TestRenderRedirects(t *testing.T) {
+	targetDir := newTestConf(t)
+	c := newTestContext(fixtureSourceDir, targetDir)
+
+	articles = []*Article{
+		{Aliases: []string{"/old/first-article"}, Slug: "first-article", TinySlug: "1"},
+	}
+	pages = []*Page{{Slug: "about"}}
+
+	render := func(articlesChanged bool) {
+		t.Helper()
+		if _, err := renderRedirects(c, articles, articlesChanged); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assertOutputs := func(expected ...string) {
+		t.Helper()
+		utesting.AssertEqual(t, expected, listGoldenOutputs(t, targetDir))
+	}
+
+	render(true)
+	assertOutputs("a/1", "old/first-article")
+
+	// A stub for an alias that was removed is removed along with it.
+	articles[0].Aliases = nil
+	render(true)
+	assertOutputs("a/1")
+
+	// Changing formats replaces redirects even if no article changed.
+	conf.RedirectFormat = uredirect.FormatNetlify
+	render(false)
+	assertOutputs(uredirect.NetlifyFilename)
+
+	conf.RedirectFormat = uredirect.FormatS3
+	render(false)
+	assertOutputs("a/1", uredirect.S3Filename)
+
+	for _, alias := range []string{"/about", "/archive/2011", "/articles.atom", "/first-article"} {
+		articles[0].Aliases = []string{alias}
+		if _, err := renderRedirects(c, articles, true); err == nil ||
+			!strings.Contains(err.Error(), "conflicts with") {
+			t.Errorf("expected alias '%s' to conflict, got %v", alias, err)
+		}
+	}
+
+	articles[0].Aliases = []string{"../../outside"}
+	if _, err := renderRedirects(c, articles, true); err == nil ||
+		!strings.Contains(err.Error(), "invalid redirect") {
+		t.Errorf("expected alias outside of target directory to be invalid, got %v", err)
+	}
+	if fileExists(filepath.Join(targetDir, "../../outside")) {
+		t.Errorf("expected no stub outside of target directory")
+	}
+}
+
+func TestServeHandler(t *testing.T) {
+	targetDir := t.TempDir()
+
+	for file, data := range map[string]string{
+		"404.html":            "not found",
+		"articles/index.html": "articles",
+		"first-article":       "first article",
+		"index.html":          "index",
+		"old":                 "stub",
+		"robots.txt":          "robots",
+		"s3-redirects.tsv":    "/old\t/first-article\n",
+	} {
+		utesting.WriteFile(t, filepath.Join(targetDir, filepath.FromSlash(file)), data)
+	}
+
+	handler, err := newServeHandler(targetDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		path        string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"/", http.StatusOK, "text/html", "index"},
+		{"/articles", http.StatusOK, "text/html", "articles"},
+		{"/articles/index.html", http.StatusOK, "text/html", "articles"},
+		{"/first-article", http.StatusOK, "text/html", "first article"},
+		{"/missing", http.StatusNotFound, "text/html", "not found"},
+		{"/robots.txt", http.StatusOK, "text/plain", "robots"},
+	}
+	for _, tc := range testCases {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+		utesting.AssertEqual(t, tc.status, recorder.Code)
+		utesting.AssertEqual(t, tc.contentType, recorder.Header().Get("Content-Type"))
+		utesting.AssertEqual(t, tc.body, recorder.Body.String())
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/old", nil))
+	utesting.AssertEqual(t, http.StatusMovedPermanently, recorder.Code)
+	utesting.AssertEqual(t, "/first-article", recorder.Header().Get("Location"))
+}
+
+func TestSortArticles(t *testing.T) {
+	a1 := &Article{Slug: "a1", PublishedAt: testTime(t, "2011-01-01T00:00:00Z")}
+	a2 := &Article{Slug: "a2", PublishedAt: testTime(t, "2013-01-01T00:00:00Z")}
+	a3 := &Article{Slug: "a3", PublishedAt: testTime(t, "2012-01-01T00:00:00Z")}
+
+	articles := []*Article{a1, a2, a3}
+	sortArticles(articles)
+	utesting.AssertEqual(t, []*Article{a2, a3, a1}, articles)
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Helpers
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// buildRound runs a single build round in the same way that Modulir does
+// from its build loop. Run it multiple times against the same context to
+// emulate `loop`.
+func buildRound(c *modulir.Context) []error {
+	c.ResetBuild()
+	c.Pool.StartRound(0)
+
+	errors := build(c)
+
+	// Always wait on the pool, even if the build function failed, because
+	// it may have enqueued jobs before returning.
+	if waitErrors := c.Wait(); len(waitErrors) > 0 {
+		errors = append(errors, waitErrors...)
+	}
+
+	// Like Modulir's loop, only the first round is a first run.
+	c.FirstRun = false
+
+	return errors
+}
+
+// copyFixture copies fixtureSourceDir's content into a temporary directory
+// so that tests can mutate sources without touching the originals. Returns
+// the new source directory.
+func copyFixture(t *testing.T) string {
+	sourceDir := t.TempDir()
+
+	err := filepath.Walk(fixtureSourceDir+"/content", func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(fixtureSourceDir, p)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(sourceDir, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+
+		return copyFile(p, target)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sourceDir
+}
+
+// executedJobs gets the names of the jobs that did work during the last
+// build round.
+func executedJobs(c *modulir.Context) []string {
+	var names []string
+	for _, job := range c.Stats.JobsExecuted {
+		names = append(names, job.Name)
+	}
+	return names
+}
+
+func containsString(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
+
+// listGoldenOutputs lists files in the target directory that are compared
+// against golden files, relative to the target directory. Symlinked assets
+// and binary files like card images are skipped.
+func listGoldenOutputs(t *testing.T, targetDir string) []string {
+	var outputs []string
+
+	err := filepath.Walk(targetDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() || strings.HasSuffix(p, ".png") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(targetDir, p)
+		if err != nil {
+			return err
+		}
+
+		outputs = append(outputs, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return outputs
+}
+
+// newTestConf resets global configuration to a known state suitable for
+// building fixtureSourceDir, independent of the environment that tests are
+// running in. Returns a temporary target directory to build into.
+func newTestConf(t *testing.T) string {
+	targetDir := t.TempDir()
+
+	conf = Conf{
+		AbsoluteURL:      "https://mutelight.org",
+		CacheDir:         t.TempDir(),
+		Concurrency:      2,
+		HostingTarget:    "s3",
+		MutelightEnv:     "test",
+		NumAtomEntries:   20,
+		NumIndexArticles: 2,
+		RedirectFormat:   "html",
+		TargetDir:        targetDir,
+	}
+
+	// These globals persist between build loops, so clear them so that tests
+	// don't interfere with each other.
+	articles = nil
+	cardHashes = make(map[string]string)
+	fragments = nil
+	links = nil
+	pages = nil
+	renderedRedirectFormat = ""
+
+	return targetDir
+}
+
+// newTestContext produces a Modulir context which builds sourceDir into
+// targetDir.
+func newTestContext(sourceDir, targetDir string) *modulir.Context {
+	log := getLog()
+	return modulir.NewContext(&modulir.Args{
+		Concurrency: conf.Concurrency,
+		Log:         log,
+		Pool:        modulir.NewPool(log, conf.Concurrency),
+		SourceDir:   sourceDir,
+		TargetDir:   targetDir,
+	})
+}
+
+// treeEntry is a file or directory read by readTree.
+type treeEntry struct {
+	data    string
+	mode    os.FileMode
+	modTime int64
+}
+
+// outputStamp is a modification time far in the past that's set on outputs
+// so that it's possible to tell which of them a build round rewrote.
+var outputStamp = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// readTree reads every file and directory under dir, keyed by path
+// relative to dir. Symlinks aren't followed.
+func readTree(t *testing.T, dir string) map[string]treeEntry {
+	tree := make(map[string]treeEntry)
+
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		entry := treeEntry{mode: info.Mode(), modTime: info.ModTime().Unix()}
+		if info.Mode().IsRegular() {
+			data, err := ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			entry.data = string(data)
+		}
+
+		tree[filepath.ToSlash(rel)] = entry
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tree
+}
+
+// rewrittenOutputs lists outputs that were written since the last call to
+// stampOutputs.
+func rewrittenOutputs(t *testing.T, targetDir string) []string {
+	var rewritten []string
+	for _, output := range listGoldenOutputs(t, targetDir) {
+		info, err := os.Stat(filepath.Join(targetDir, output))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !info.ModTime().Equal(outputStamp) {
+			rewritten = append(rewritten, output)
+		}
+	}
+	return rewritten
+}
+
+// stampOutputs sets the modification time of every output to outputStamp.
+func stampOutputs(t *testing.T, targetDir string) {
+	for _, output := range listGoldenOutputs(t, targetDir) {
+		err := os.Chtimes(filepath.Join(targetDir, output), outputStamp, outputStamp)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testTime(t *testing.T, s string) *time.Time {
+	tm, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tm
+}
+
+// touchSource modifies a source file and moves its modification time
+// forward so that Modulir is guaranteed to see it as changed, even on
+// filesystems with coarse timestamps.
+func touchSource(t *testing.T, source string) {
+	f, err := os.OpenFile(source, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString("\nAn appended paragraph.\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(source, future, future); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,64 @@
+// Suggests articles whose slugs are closest to the path that wasn't found.
+// Candidate slugs and their titles are embedded in the page at build time.
+(function() {
+  "use strict";
+
+  var MAX_SUGGESTIONS = 5;
+
+  // Standard Levenshtein edit distance.
+  function distance(a, b) {
+    var prev = [];
+    for (var j = 0; j <= b.length; j++) {
+      prev.push(j);
+    }
+
+    for (var i = 1; i <= a.length; i++) {
+      var curr = [i];
+      for (var k = 1; k <= b.length; k++) {
+        var cost = a.charAt(i - 1) === b.charAt(k - 1) ? 0 : 1;
+        curr.push(Math.min(prev[k] + 1, curr[k - 1] + 1, prev[k - 1] + cost));
+      }
+      prev = curr;
+    }
+
+    return prev[b.length];
+  }
+
+  document.addEventListener("DOMContentLoaded", function() {
+    var container = document.getElementById("did_you_mean");
+    var candidates = JSON.parse(container.getAttribute("data-slugs"));
+
+    var path = decodeURIComponent(window.location.pathname)
+      .replace(/^\/+|\/+$/g, "")
+      .toLowerCase();
+    if (path === "") {
+      return;
+    }
+
+    var suggestions = candidates.map(function(candidate) {
+      return {candidate: candidate, distance: distance(path, candidate.slug)};
+    }).filter(function(suggestion) {
+      // Don't suggest anything that's wildly different from what was asked
+      // for.
+      return suggestion.distance <= Math.max(3, path.length / 2);
+    }).sort(function(a, b) {
+      return a.distance - b.distance;
+    }).slice(0, MAX_SUGGESTIONS);
+
+    if (suggestions.length === 0) {
+      return;
+    }
+
+    var list = document.getElementById("did_you_mean_list");
+    suggestions.forEach(function(suggestion) {
+      var item = document.createElement("li");
+      var link = document.createElement("a");
+      link.href = "/" + suggestion.candidate.slug;
+      link.textContent = suggestion.candidate.title;
+      item.appendChild(link);
+      list.appendChild(item);
+    });
+
+    container.style.display = "block";
+  });
+})();
@@ -0,0 +1,170 @@
+// Queries the search index built by the `usearch` package entirely in the
+// browser. Tokenizing and stemming here must match `Tokenize` and `Stem` in
+// `modules/usearch/usearch.go` exactly, or queries won't find the terms that
+// were indexed.
+(function() {
+  "use strict";
+
+  var MIN_TOKEN_LENGTH = 2;
+  var MAX_RESULTS = 25;
+
+  var STOP_WORDS = {};
+  ("a about an and are as at be but by can for from had has have he i if in " +
+    "into is it its just me my not of on or so some such than that the " +
+    "their them then there these they this to was we were what when which " +
+    "will with would you your").split(" ").forEach(function(word) {
+    STOP_WORDS[word] = true;
+  });
+
+  function runeLength(s) {
+    return Array.from(s).length;
+  }
+
+  function endsWith(s, suffix) {
+    return s.length >= suffix.length && s.slice(s.length - suffix.length) === suffix;
+  }
+
+  function stem(word) {
+    if (runeLength(word) <= 3) {
+      return word;
+    }
+
+    // Plurals.
+    if (endsWith(word, "sses")) {
+      word = word.slice(0, -2);
+    } else if (endsWith(word, "ies")) {
+      word = word.slice(0, -3) + "y";
+    } else if (endsWith(word, "s") && !endsWith(word, "ss") &&
+        !endsWith(word, "us") && !endsWith(word, "is")) {
+      word = word.slice(0, -1);
+    }
+
+    // Verb and adverb endings.
+    var suffixes = ["ingly", "edly", "ing", "ed", "ly"];
+    for (var i = 0; i < suffixes.length; i++) {
+      var suffix = suffixes[i];
+      if (!endsWith(word, suffix)) {
+        continue;
+      }
+
+      var s = word.slice(0, -suffix.length);
+      if (runeLength(s) < 3 || !/[aeiouy]/.test(s)) {
+        break;
+      }
+
+      // Undouble a trailing consonant, like in "running".
+      var last = s.charAt(s.length - 1);
+      if (/[a-z]/.test(last) && last === s.charAt(s.length - 2) &&
+          "aeioulsz".indexOf(last) === -1) {
+        s = s.slice(0, -1);
+      }
+
+      word = s;
+      break;
+    }
+
+    return word;
+  }
+
+  // Lowercases one character at a time like Go's `strings.ToLower`. Unlike
+  // it, `toLowerCase` on a whole string lowercases some characters depending
+  // on their neighbours, like a final sigma, and others to more than one
+  // character, like "İ", which is reduced to its first here.
+  function toLower(s) {
+    return Array.from(s).map(function(c) {
+      return Array.from(c.toLowerCase())[0];
+    }).join("");
+  }
+
+  function tokenize(s) {
+    return toLower(s).split(/[^\p{L}\p{N}]+/u).filter(function(word) {
+      return runeLength(word) >= MIN_TOKEN_LENGTH && !STOP_WORDS[word];
+    }).map(stem);
+  }
+
+  // Scores each document by summing the scores of its matching terms, each
+  // weighted by how rare the term is across all documents.
+  function search(index, query) {
+    var scores = {};
+    var numDocs = index.docs.length;
+
+    tokenize(query).forEach(function(term) {
+      var postings = index.terms[term];
+      if (!postings) {
+        return;
+      }
+
+      var idf = Math.log(1 + numDocs / postings.length);
+      postings.forEach(function(posting) {
+        scores[posting[0]] = (scores[posting[0]] || 0) + posting[1] * idf;
+      });
+    });
+
+    return Object.keys(scores).map(function(i) {
+      return {doc: index.docs[i], score: scores[i]};
+    }).sort(function(a, b) {
+      return b.score - a.score;
+    }).slice(0, MAX_RESULTS);
+  }
+
+  function render(results, query) {
+    var list = document.getElementById("search_results");
+    var status = document.getElementById("search_status");
+
+    list.innerHTML = "";
+
+    if (query.trim() === "") {
+      status.textContent = "";
+      return;
+    }
+
+    status.textContent = results.length === 0 ?
+      "No articles matched." :
+      results.length + (results.length === 1 ? " article" : " articles") + " matched.";
+
+    results.forEach(function(result) {
+      var item = document.createElement("li");
+
+      var link = document.createElement("a");
+      link.href = "/" + result.doc.s;
+      link.textContent = result.doc.t;
+      item.appendChild(link);
+
+      var date = document.createElement("span");
+      date.className = "publish_date";
+      date.textContent = " — " + result.doc.d;
+      item.appendChild(date);
+
+      list.appendChild(item);
+    });
+  }
+
+  // Under Node, expose tokenizing and searching instead of wiring up the
+  // page so that tests can check them against the Go implementation. See
+  // `modules/usearch/usearch_test.go`.
+  if (typeof module !== "undefined" && module.exports) {
+    module.exports = {search: search, stem: stem, tokenize: tokenize};
+    return;
+  }
+
+  document.addEventListener("DOMContentLoaded", function() {
+    var input = document.getElementById("search_query");
+    var params = new URLSearchParams(window.location.search);
+    input.value = params.get("q") || "";
+
+    fetch(input.getAttribute("data-index")).then(function(resp) {
+      return resp.json();
+    }).then(function(index) {
+      var update = function() {
+        render(search(index, input.value), input.value);
+
+        // Keep the URL in sync so that searches can be linked to.
+        var url = input.value ? "?q=" + encodeURIComponent(input.value) : window.location.pathname;
+        window.history.replaceState(null, "", url);
+      };
+
+      input.addEventListener("input", update);
+      update();
+    });
+  });
+})();
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/brandur/mutelight/modules/umanifest"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Private
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// diffManifests compares the build manifests at oldFilename and newFilename
+// and prints outputs that were added, changed, or removed between them.
+func diffManifests(oldFilename, newFilename string) error {
+	oldManifest, err := umanifest.Read(oldFilename)
+	if err != nil {
+		return err
+	}
+
+	newManifest, err := umanifest.Read(newFilename)
+	if err != nil {
+		return err
+	}
+
+	printDiff(os.Stdout, umanifest.Compare(oldManifest, newManifest))
+	return nil
+}
+
+// printDiff prints a diff with one output per line, prefixed with `+` if it
+// was added, `~` if it changed, or `-` if it was removed, followed by a
+// summary.
+func printDiff(w io.Writer, diff *umanifest.Diff) {
+	if diff.Empty() {
+		fmt.Fprintf(w, "No changes\n")
+		return
+	}
+
+	for _, p := range diff.Added {
+		fmt.Fprintf(w, "+ %s\n", p)
+	}
+	for _, p := range diff.Changed {
+		fmt.Fprintf(w, "~ %s\n", p)
+	}
+	for _, p := range diff.Removed {
+		fmt.Fprintf(w, "- %s\n", p)
+	}
+
+	fmt.Fprintf(w, "\n%d added, %d changed, %d removed\n",
+		len(diff.Added), len(diff.Changed), len(diff.Removed))
+}
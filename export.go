@@ -0,0 +1,176 @@
+package main
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/modulir"
+	"github.com/brandur/mutelight/modules/uarchive"
+	"github.com/brandur/mutelight/modules/ucompress"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Private
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// aliasIndexFiles gives every directory index in dir, like
+// `archive/index.html`, an alias at `archive.html`. This is the equivalent
+// of the deploy step which uploads indexes at their directory's name, but a
+// file can't share a name with a directory outside of S3, so the alias gets
+// an extension instead. Most static hosts serve `/archive` from
+// `archive.html`.
+func aliasIndexFiles(dir string) ([]string, error) {
+	var aliases []string
+
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.Name() != "index.html" || filepath.Dir(p) == filepath.Clean(dir) {
+			return nil
+		}
+
+		alias := filepath.Dir(p) + ".html"
+		if fileExists(alias) {
+			return nil
+		}
+
+		aliases = append(aliases, alias)
+		return copyFile(p, alias)
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("error aliasing index files: %w", err)
+	}
+
+	return aliases, nil
+}
+
+// exportSite builds the site into a temporary directory and packages it into
+// an archive at filename (either `.tar.gz` or `.zip`) that can be handed off
+// to any static host. Like the `build` command, it exits the program if the
+// build fails.
+func exportSite(filename string) error {
+	format, err := uarchive.FormatFromFilename(filename)
+	if err != nil {
+		return err
+	}
+
+	// Exports are always reproducible, which among other things means that
+	// assets are copied into the target directory instead of being symlinked
+	// back into the source directory.
+	epoch, err := sourceDateEpoch()
+	if err != nil {
+		return err
+	}
+	conf.Reproducible = true
+	conf.SourceDateEpoch = epoch
+	rand.Seed(conf.SourceDateEpoch)
+
+	// Exports are handed off to hosts that may not compress on the fly, so
+	// they're precompressed unless PRECOMPRESS is set to say otherwise.
+	if _, ok := os.LookupEnv("PRECOMPRESS"); !ok {
+		conf.Precompress = true
+	}
+
+	targetDir, err := ioutil.TempDir("", "mutelight-export")
+	if err != nil {
+		return xerrors.Errorf("error creating temporary directory: %w", err)
+	}
+	conf.TargetDir = targetDir
+
+	config := getModulirConfig()
+	modulir.Build(config, func(c *modulir.Context) []error {
+		// Modulir exits if the build fails, so the temporary directory is
+		// removed here rather than with a defer.
+		defer os.RemoveAll(targetDir)
+
+		if errors := build(c); len(errors) > 0 {
+			return errors
+		}
+
+		if err := packageSite(c, filename, format); err != nil {
+			return []error{err}
+		}
+
+		return nil
+	})
+
+	return nil
+}
+
+// packageSite writes an archive of a completed build at filename in the
+// given format.
+func packageSite(c *modulir.Context, filename, format string) error {
+	aliases, err := aliasIndexFiles(c.TargetDir)
+	if err != nil {
+		return err
+	}
+
+	if conf.Precompress {
+		for _, alias := range aliases {
+			if _, err := ucompress.Compress(alias); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Aliases were added after the build, so bring the manifest up to date.
+	if _, err := renderManifest(c, path.Join(c.TargetDir, "assets", Release)); err != nil {
+		return err
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return xerrors.Errorf("error creating file '%s': %w", filename, err)
+	}
+
+	if err := uarchive.Write(f, format, c.TargetDir, time.Unix(conf.SourceDateEpoch, 0)); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return xerrors.Errorf("error closing file '%s': %w", filename, err)
+	}
+
+	c.Log.Infof("Exported site to %s", filename)
+	return nil
+}
+
+// sourceDateEpoch gets the Unix timestamp that an export is stamped with:
+// SOURCE_DATE_EPOCH if it's set, or the time of the last commit otherwise so
+// that exporting the same commit twice produces the same archive.
+func sourceDateEpoch() (int64, error) {
+	if _, ok := os.LookupEnv("SOURCE_DATE_EPOCH"); ok {
+		return conf.SourceDateEpoch, nil
+	}
+
+	out, err := exec.Command("git", "log", "-1", "--format=%ct").Output()
+	if err != nil {
+		return 0, xerrors.Errorf("error getting time of last commit "+
+			"(set SOURCE_DATE_EPOCH if not building from a Git checkout): %w", err)
+	}
+
+	epoch, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0, xerrors.Errorf("error parsing time of last commit: %w", err)
+	}
+
+	return epoch, nil
+}
@@ -3,8 +3,10 @@ module github.com/brandur/mutelight
 go 1.16
 
 require (
+	github.com/andybalholm/brotli v1.0.3
 	github.com/brandur/modulir v0.0.0-20210918175748-8578b95b4e98
 	github.com/fsnotify/fsnotify v1.5.1 // indirect
+	github.com/gorilla/websocket v1.4.2
 	github.com/joeshaw/envdecode v0.0.0-20200121155833-099f1fc765bd
 	github.com/logrusorgru/aurora v2.0.3+incompatible // indirect
 	github.com/pelletier/go-toml v1.8.1 // indirect
@@ -13,6 +15,7 @@ require (
 	github.com/spf13/cobra v1.1.1
 	github.com/yosssi/ace v0.0.5
 	golang.org/x/crypto v0.0.0-20190605123033-f99c8df09eb5
+	golang.org/x/image v0.0.0-20210628002857-a66eb6448b8d
 	golang.org/x/sys v0.0.0-20210917161153-d61c044b1678 // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1
 )
@@ -0,0 +1,33 @@
+// Package utesting contains helpers shared by the tests of the main package
+// and its modules.
+package utesting
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// AssertEqual fails the test if expected and actual aren't deeply equal.
+func AssertEqual(t *testing.T, expected, actual interface{}) {
+	t.Helper()
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+// WriteFile writes data to filename, creating any directories that lead up
+// to it, and fails the test if it can't.
+func WriteFile(t *testing.T, filename, data string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filename, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
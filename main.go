@@ -13,6 +13,10 @@ import (
 	"golang.org/x/crypto/ssh/terminal"
 
 	"github.com/brandur/modulir"
+	"github.com/brandur/mutelight/modules/ucommon"
+	"github.com/brandur/mutelight/modules/uhosting"
+	"github.com/brandur/mutelight/modules/uoverlay"
+	"github.com/brandur/mutelight/modules/uviews"
 )
 
 //////////////////////////////////////////////////////////////////////////////
@@ -45,8 +49,45 @@ when they're detected. A webserver is started on PORT (default
 			modulir.Build(getModulirConfig(), build)
 		},
 	}
+	buildCommand.Flags().StringVar(&conf.HostingTarget, "target", uhosting.TargetS3,
+		"Hosting target to build for (s3, netlify, github-pages, or nginx)")
 	rootCmd.AddCommand(buildCommand)
 
+	diffCommand := &cobra.Command{
+		Use:   "diff <old manifest> <new manifest>",
+		Short: "Compare build manifests",
+		Long: strings.TrimSpace(`
+Compares two build manifests (manifest.json in TARGET_DIR) and
+prints the outputs that were added, changed, or removed between
+them. Useful for seeing what a deploy will change.`),
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := diffManifests(args[0], args[1]); err != nil {
+				ucommon.ExitWithError(err)
+			}
+		},
+	}
+	rootCmd.AddCommand(diffCommand)
+
+	exportCommand := &cobra.Command{
+		Use:   "export <archive>",
+		Short: "Export the site as an archive",
+		Long: strings.TrimSpace(`
+Builds the site into a temporary directory with assets copied
+rather than symlinked, and packages it along with its manifest
+into a .tar.gz or .zip archive suitable for uploading to any
+static host.`),
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := exportSite(args[0]); err != nil {
+				ucommon.ExitWithError(err)
+			}
+		},
+	}
+	exportCommand.Flags().StringVar(&conf.HostingTarget, "target", uhosting.TargetS3,
+		"Hosting target to build for (s3, netlify, github-pages, or nginx)")
+	rootCmd.AddCommand(exportCommand)
+
 	loopCommand := &cobra.Command{
 		Use:   "loop",
 		Short: "Start build and serve loop",
@@ -54,20 +95,132 @@ when they're detected. A webserver is started on PORT (default
 Runs the build loop one time and places the result in TARGET_DIR
 (default ./public/).`),
 		Run: func(cmd *cobra.Command, args []string) {
-			modulir.BuildLoop(getModulirConfig(), build)
+			// Modulir's server runs on an internal port behind our own,
+			// which adds niceties like serving the site's 404 page.
+			config := getModulirConfig()
+			config.Port = conf.ModulirPort
+
+			// In development, build errors are also shown in the browser
+			// over a websocket of our own, which also tells pages how to
+			// reload after a successful build.
+			var hub *uoverlay.Hub
+			if config.Websocket {
+				hub = uoverlay.NewHub()
+			}
+
+			startLoopServer(conf.Port, conf.ModulirPort, conf.TargetDir, hub)
+
+			modulir.BuildLoop(config, func(c *modulir.Context) []error {
+				errors := build(c)
+				if hub != nil {
+					hub.Publish(newBuildErrors(errors), reloadKind(c))
+				}
+				return errors
+			})
 		},
 	}
 	rootCmd.AddCommand(loopCommand)
 
-	// Make sure to seed the random number generator or else we'll end up with
-	// the same random results for every build.
-	rand.Seed(time.Now().UnixNano())
+	var searchOpts searchOptions
+	searchCommand := &cobra.Command{
+		Use:   "search <query>",
+		Short: "Search articles",
+		Long: strings.TrimSpace(`
+Searches the full text of every article for the given query and
+prints matches ranked by relevance along with a snippet of their
+content. Useful for finding older articles to cross-link.`),
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := searchArticles(strings.Join(args, " "), &searchOpts); err != nil {
+				ucommon.ExitWithError(err)
+			}
+		},
+	}
+	searchCommand.Flags().BoolVar(&searchOpts.JSON, "json", false,
+		"Print results as JSON")
+	searchCommand.Flags().IntVar(&searchOpts.Limit, "limit", 10,
+		"Maximum number of results to print (0 for no limit)")
+	rootCmd.AddCommand(searchCommand)
+
+	serveCommand := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve a built site like production",
+		Long: strings.TrimSpace(`
+Serves the site already built in TARGET_DIR on PORT without
+rebuilding it. Content types, cache headers, precompressed
+outputs, directory indexes, redirects, and 404s are handled the
+same way as once deployed, which makes it useful for a final
+check before running 'make deploy'.`),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := serveSite(conf.Port, conf.TargetDir); err != nil {
+				ucommon.ExitWithError(err)
+			}
+		},
+	}
+	rootCmd.AddCommand(serveCommand)
+
+	var uploadsOpts uploadsOptions
+	uploadsCommand := &cobra.Command{
+		Use:   "uploads <target dir>",
+		Short: "List objects uploaded on deploy",
+		Long: strings.TrimSpace(`
+Lists the objects that 'make deploy' uploads to S3 for a built
+site, one per line as tab-separated key, file, content type,
+cache control, content encoding, and redirect. Headers come
+from the same rules used by the loop server and other hosting
+targets. With --since, only objects that changed since the
+given manifest are listed.`),
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := showUploads(args[0], &uploadsOpts); err != nil {
+				ucommon.ExitWithError(err)
+			}
+		},
+	}
+	uploadsCommand.Flags().StringVar(&uploadsOpts.Since, "since", "",
+		"Manifest of the last deploy; only list objects changed since")
+	rootCmd.AddCommand(uploadsCommand)
+
+	viewsCommand := &cobra.Command{
+		Use:   "views",
+		Short: "Print the dependency graph of views",
+		Long: strings.TrimSpace(`
+Prints every view and layout followed by the partials that it
+includes, which is the graph that the build uses to decide
+which pages to rebuild when a partial changes. Useful for
+debugging why a page was or wasn't rebuilt.`),
+		Run: func(cmd *cobra.Command, args []string) {
+			graph, err := uviews.Build(ucommon.LayoutsDir, ucommon.ViewsDir)
+			if err != nil {
+				ucommon.ExitWithError(err)
+			}
+			if err := graph.Write(os.Stdout); err != nil {
+				ucommon.ExitWithError(err)
+			}
+		},
+	}
+	rootCmd.AddCommand(viewsCommand)
 
 	if err := envdecode.Decode(&conf); err != nil {
 		fmt.Fprintf(os.Stderr, "Error decoding conf from env: %v", err)
 		os.Exit(1)
 	}
 
+	// Checked by presence rather than value because zero, the Unix epoch,
+	// is a perfectly valid timestamp.
+	if _, ok := os.LookupEnv("SOURCE_DATE_EPOCH"); ok {
+		conf.Reproducible = true
+	}
+
+	// Make sure to seed the random number generator or else we'll end up with
+	// the same random results for every build. That's exactly what a
+	// reproducible build wants though, so seed it with a constant instead.
+	if conf.Reproducible {
+		rand.Seed(conf.SourceDateEpoch)
+	} else {
+		rand.Seed(time.Now().UnixNano())
+	}
+
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error executing command: %v", err)
 		os.Exit(1)
@@ -105,6 +258,15 @@ type Conf struct {
 	// It's used for things like Atom feeds.
 	AbsoluteURL string `env:"ABSOLUTE_URL,default=https://mutelight.org"`
 
+	// AtomSortByUpdated is whether entries in Atom feeds should be ordered by
+	// when they were last updated instead of when they were published. This
+	// makes sure that updates to older articles are seen by readers.
+	AtomSortByUpdated bool `env:"ATOM_SORT_BY_UPDATED,default=false"`
+
+	// CacheDir is a directory where artifacts that are expensive to generate,
+	// like social card images, are cached between builds.
+	CacheDir string `env:"CACHE_DIR,default=./.cache"`
+
 	// Concurrency is the number of build Goroutines that will be used to
 	// perform build work items.
 	Concurrency int `env:"CONCURRENCY,default=30"`
@@ -123,12 +285,59 @@ type Conf struct {
 	// GoogleAnalyticsID is the account identifier for Google Analytics to use.
 	GoogleAnalyticsID string `env:"GOOGLE_ANALYTICS_ID"`
 
+	// HostingTarget is the host that the site is being built for. One of `s3`
+	// (the default, deployed with `make deploy`), `netlify`, `github-pages`,
+	// or `nginx`. Targets other than `s3` post-process output to suit their
+	// host, and may override RedirectFormat. Can also be set with `--target`.
+	HostingTarget string `env:"HOSTING_TARGET,default=s3"`
+
+	// ModulirPort is the port on which Modulir's own development server
+	// listens when looping. It's not used directly; the loop's server on
+	// Port proxies to it.
+	ModulirPort int `env:"MODULIR_PORT,default=5010"`
+
+	// NginxRoot is the directory that nginx serves the site from when
+	// building for the `nginx` hosting target.
+	NginxRoot string `env:"NGINX_ROOT,default=/var/www/mutelight"`
+
 	// NumAtomEntries is the number of entries to put in Atom feeds.
 	NumAtomEntries int `env:"NUM_ATOM_ENTRIES,default=20"`
 
+	// NumIndexArticles is the number of articles to show on each page of the
+	// paginated home page.
+	NumIndexArticles int `env:"NUM_INDEX_ARTICLES,default=10"`
+
 	// Port is the port on which to serve HTTP when looping in development.
 	Port int `env:"PORT,default=5009"`
 
+	// Precompress is whether gzip and Brotli compressed siblings of outputs
+	// like HTML pages and Atom feeds are produced as the final phase of a
+	// build so that they can be served without compressing them on the fly.
+	// It makes builds slower and copies assets rather than symlinking them,
+	// so it's off by default and turned on for production builds that are
+	// deployed, and for exports.
+	Precompress bool `env:"PRECOMPRESS,default=false"`
+
+	// RedirectFormat is the format in which redirects, like those from an
+	// article's tiny slug or old aliases, are produced. One of `html` (meta
+	// refresh stubs), `s3` (stubs plus a list of redirects that the deploy
+	// step sets as S3 redirect metadata), `netlify` (a `_redirects` file), or
+	// `nginx` (a map file to be included in Nginx configuration).
+	RedirectFormat string `env:"REDIRECT_FORMAT,default=html"`
+
+	// Reproducible is whether the build should produce byte-identical output
+	// for identical sources, regardless of when or where it runs. Assets are
+	// copied instead of symlinked, and every output is given the modification
+	// time in SourceDateEpoch.
+	Reproducible bool `env:"REPRODUCIBLE,default=false"`
+
+	// SourceDateEpoch is a Unix timestamp used in place of the current time
+	// by reproducible builds. Setting it, even to `0`, activates
+	// Reproducible. See:
+	//
+	//     https://reproducible-builds.org/specs/source-date-epoch/
+	SourceDateEpoch int64 `env:"SOURCE_DATE_EPOCH"`
+
 	// TargetDir is the target location where the site will be built to.
 	TargetDir string `env:"TARGET_DIR,default=./public"`
 
@@ -162,6 +371,20 @@ func getLog() modulir.LoggerInterface {
 	return log
 }
 
+// getModulirContext produces a context suitable for using Modulir's helpers
+// outside of a build loop, like from commands that parse content without
+// building the site.
+func getModulirContext() *modulir.Context {
+	config := getModulirConfig()
+	return modulir.NewContext(&modulir.Args{
+		Concurrency: config.Concurrency,
+		Log:         config.Log,
+		LogColor:    config.LogColor,
+		SourceDir:   config.SourceDir,
+		TargetDir:   config.TargetDir,
+	})
+}
+
 // getModulirConfig interprets Conf to produce a configuration suitable to pass
 // to a Modulir build loop.
 func getModulirConfig() *modulir.Config {
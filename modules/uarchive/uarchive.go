@@ -0,0 +1,225 @@
+// Package uarchive packages a directory into a `.tar.gz` or `.zip` archive.
+// Archives are deterministic: entries are sorted, and every entry gets the
+// same modification time and permissions so that the same directory always
+// produces the same archive.
+package uarchive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Constants
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+const (
+	// FormatTarGz is a gzip compressed tarball.
+	FormatTarGz = "tar.gz"
+
+	// FormatZip is a zip file.
+	FormatZip = "zip"
+)
+
+// The earliest time that can be represented in a zip file, which stores
+// times in MS-DOS format.
+var minZipTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Functions
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// FormatFromFilename gets an archive format from the extension of filename,
+// like `site.tar.gz` or `site.zip`.
+func FormatFromFilename(filename string) (string, error) {
+	switch {
+	case strings.HasSuffix(filename, ".tar.gz"), strings.HasSuffix(filename, ".tgz"):
+		return FormatTarGz, nil
+	case strings.HasSuffix(filename, ".zip"):
+		return FormatZip, nil
+	}
+	return "", xerrors.Errorf("unknown archive format for '%s' (use .tar.gz or .zip)", filename)
+}
+
+// Write archives the contents of dir to w in the given format. Symlinks are
+// followed so that the archive contains real files. Every entry is given
+// modTime.
+func Write(w io.Writer, format, dir string, modTime time.Time) error {
+	files, err := listFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case FormatTarGz:
+		return writeTarGz(w, dir, files, modTime)
+	case FormatZip:
+		return writeZip(w, dir, files, modTime)
+	}
+	return xerrors.Errorf("unknown archive format: %s", format)
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Private
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// addFile copies the file at the given path relative to dir into the writer
+// returned by create, which is invoked with the file's size.
+func addFile(dir, file string, create func(size int64) (io.Writer, error)) error {
+	filename := filepath.Join(dir, filepath.FromSlash(file))
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return xerrors.Errorf("error opening file '%s': %w", filename, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return xerrors.Errorf("error stating file '%s': %w", filename, err)
+	}
+
+	w, err := create(info.Size())
+	if err != nil {
+		return xerrors.Errorf("error adding '%s' to archive: %w", file, err)
+	}
+
+	if _, err := io.Copy(w, f); err != nil {
+		return xerrors.Errorf("error adding '%s' to archive: %w", file, err)
+	}
+
+	return nil
+}
+
+// listFiles lists every file under dir as slash-separated paths relative to
+// it, in sorted order.
+func listFiles(dir string) ([]string, error) {
+	var files []string
+
+	var walk func(p string) error
+	walk = func(p string) error {
+		infos, err := ioutil.ReadDir(filepath.Join(dir, p))
+		if err != nil {
+			return xerrors.Errorf("error reading directory '%s': %w", filepath.Join(dir, p), err)
+		}
+
+		for _, entry := range infos {
+			child := filepath.Join(p, entry.Name())
+
+			// ReadDir doesn't follow symlinks, so stat again to find out
+			// what they point to.
+			info, err := os.Stat(filepath.Join(dir, child))
+			if err != nil {
+				return xerrors.Errorf("error stating file '%s': %w", filepath.Join(dir, child), err)
+			}
+
+			if info.IsDir() {
+				if err := walk(child); err != nil {
+					return err
+				}
+				continue
+			}
+
+			files = append(files, filepath.ToSlash(child))
+		}
+
+		return nil
+	}
+
+	if err := walk(""); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+func writeTarGz(w io.Writer, dir string, files []string, modTime time.Time) error {
+	// The gzip header includes a modification time of its own, which is left
+	// empty so that it doesn't vary.
+	gzipWriter := gzip.NewWriter(w)
+
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	for _, file := range files {
+		err := addFile(dir, file, func(size int64) (io.Writer, error) {
+			return tarWriter, tarWriter.WriteHeader(&tar.Header{
+				Format:   tar.FormatPAX,
+				Mode:     0o644,
+				ModTime:  modTime,
+				Name:     file,
+				Size:     size,
+				Typeflag: tar.TypeReg,
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return xerrors.Errorf("error closing tarball: %w", err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return xerrors.Errorf("error closing gzip writer: %w", err)
+	}
+
+	return nil
+}
+
+func writeZip(w io.Writer, dir string, files []string, modTime time.Time) error {
+	if modTime.Before(minZipTime) {
+		modTime = minZipTime
+	}
+
+	zipWriter := zip.NewWriter(w)
+
+	for _, file := range files {
+		err := addFile(dir, file, func(size int64) (io.Writer, error) {
+			header := &zip.FileHeader{
+				Method:   zip.Deflate,
+				Modified: modTime.UTC(),
+				Name:     file,
+			}
+			header.SetMode(0o644)
+			return zipWriter.CreateHeader(header)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := zipWriter.Close(); err != nil {
+		return xerrors.Errorf("error closing zip file: %w", err)
+	}
+
+	return nil
+}
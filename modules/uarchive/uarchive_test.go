@@ -0,0 +1,148 @@
+package uarchive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/brandur/mutelight/internal/utesting"
+)
+
+func TestFormatFromFilename(t *testing.T) {
+	for filename, format := range map[string]string{
+		"site.tar.gz": FormatTarGz,
+		"site.tgz":    FormatTarGz,
+		"site.zip":    FormatZip,
+	} {
+		actual, err := FormatFromFilename(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != format {
+			t.Errorf("expected format '%s' for '%s', got '%s'", format, filename, actual)
+		}
+	}
+
+	if _, err := FormatFromFilename("site.rar"); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	sourceDir := t.TempDir()
+	dir := t.TempDir()
+
+	utesting.WriteFile(t, filepath.Join(dir, "index.html"), "index")
+	utesting.WriteFile(t, filepath.Join(dir, "archive/index.html"), "archive")
+	utesting.WriteFile(t, filepath.Join(sourceDir, "icon.png"), "png")
+
+	if err := os.Symlink(sourceDir, filepath.Join(dir, "images")); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"archive/index.html": "archive",
+		"images/icon.png":    "png",
+		"index.html":         "index",
+	}
+
+	modTime := time.Unix(1300000000, 0)
+
+	t.Run("TarGz", func(t *testing.T) {
+		data := writeArchive(t, FormatTarGz, dir, modTime)
+
+		// Archives of the same directory are identical.
+		if !bytes.Equal(data, writeArchive(t, FormatTarGz, dir, modTime)) {
+			t.Errorf("expected archives to be identical")
+		}
+
+		gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		contents := make(map[string]string)
+		tarReader := tar.NewReader(gzipReader)
+		for {
+			header, err := tarReader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !header.ModTime.Equal(modTime) {
+				t.Errorf("expected modification time %v for '%s', got %v",
+					modTime, header.Name, header.ModTime)
+			}
+
+			contents[header.Name] = readAll(t, tarReader)
+		}
+
+		if !reflect.DeepEqual(expected, contents) {
+			t.Errorf("expected %v, got %v", expected, contents)
+		}
+	})
+
+	t.Run("Zip", func(t *testing.T) {
+		data := writeArchive(t, FormatZip, dir, modTime)
+
+		if !bytes.Equal(data, writeArchive(t, FormatZip, dir, modTime)) {
+			t.Errorf("expected archives to be identical")
+		}
+
+		zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		contents := make(map[string]string)
+		for _, file := range zipReader.File {
+			f, err := file.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			contents[file.Name] = readAll(t, f)
+			f.Close()
+		}
+
+		if !reflect.DeepEqual(expected, contents) {
+			t.Errorf("expected %v, got %v", expected, contents)
+		}
+	})
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Helpers
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+func readAll(t *testing.T, r io.Reader) string {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func writeArchive(t *testing.T, format, dir string, modTime time.Time) []byte {
+	var buf bytes.Buffer
+	if err := Write(&buf, format, dir, modTime); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,258 @@
+// Package ucard renders social card images for articles. These are the images
+// that services like Twitter and Slack show when a link to an article is
+// shared.
+//
+// Rendering is done in pure Go using the Go fonts so that the build doesn't
+// depend on anything installed on the system.
+package ucard
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/xerrors"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Constants
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+const (
+	// Height is the height of rendered cards in pixels.
+	Height = 630
+
+	// Version is mixed into every card's hash. Bump it when the way cards are
+	// rendered changes so that any cached cards are invalidated.
+	Version = "1"
+
+	// Width is the width of rendered cards in pixels. Along with Height, it
+	// produces the 1.91:1 ratio recommended for OpenGraph images.
+	Width = 1200
+
+	// margin is the space left between the edges of the card and its text.
+	margin = 80
+
+	// maxTitleLines is the maximum number of lines that a title will be
+	// wrapped to. Anything beyond it is truncated with an ellipsis.
+	maxTitleLines = 4
+)
+
+// Colors borrowed from the site's stylesheet so that cards look like they
+// belong to it.
+var (
+	backgroundColor = color.RGBA{0x00, 0x00, 0x00, 0xff}
+	overlayColor    = color.RGBA{0x00, 0x00, 0x00, 0xb0}
+	siteNameColor   = color.RGBA{0xbd, 0xbd, 0x8c, 0xff}
+	subtitleColor   = color.RGBA{0x8d, 0x8b, 0x98, 0xff}
+	titleColor      = color.RGBA{0xe0, 0xe0, 0xe0, 0xff}
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Types
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Options are the contents of a card to be rendered.
+type Options struct {
+	// Background is an optional image drawn behind the card's text. It's
+	// scaled and cropped to fill the card and darkened so that text remains
+	// legible.
+	Background image.Image
+
+	// BackgroundHash is a hash of the background image's source which is used
+	// to determine whether a card needs to be rerendered. It should be set
+	// whenever Background is.
+	BackgroundHash string
+
+	// SiteName is the name of the site shown at the bottom of the card.
+	SiteName string
+
+	// Subtitle is a line of smaller text shown below the title, like the date
+	// that the article was published.
+	Subtitle string
+
+	// Title is the main text of the card.
+	Title string
+}
+
+// Hash produces a stable hash of the card's contents which changes whenever
+// the rendered card would.
+func (o *Options) Hash() string {
+	h := sha256.New()
+	for _, s := range []string{Version, o.BackgroundHash, o.SiteName, o.Subtitle, o.Title} {
+		// Write a separator so that the boundaries between fields are
+		// unambiguous.
+		_, _ = io.WriteString(h, s+"\x00")
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Functions
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Render renders a card as a PNG to the given writer.
+func Render(w io.Writer, opts *Options) error {
+	img := image.NewRGBA(image.Rect(0, 0, Width, Height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(backgroundColor), image.Point{}, draw.Src)
+
+	if opts.Background != nil {
+		drawBackground(img, opts.Background)
+	}
+
+	titleFace, err := newFace(gobold.TTF, 64)
+	if err != nil {
+		return err
+	}
+	defer titleFace.Close()
+
+	smallFace, err := newFace(goregular.TTF, 32)
+	if err != nil {
+		return err
+	}
+	defer smallFace.Close()
+
+	// Title lines start from the top margin and run downwards.
+	lines := wrapText(titleFace, opts.Title, Width-2*margin, maxTitleLines)
+	lineHeight := titleFace.Metrics().Height.Ceil() + 12
+	y := margin + titleFace.Metrics().Ascent.Ceil()
+	for _, line := range lines {
+		drawText(img, titleFace, titleColor, margin, y, line)
+		y += lineHeight
+	}
+
+	if opts.Subtitle != "" {
+		drawText(img, smallFace, subtitleColor, margin, y+16, opts.Subtitle)
+	}
+
+	// The site's name is anchored to the bottom of the card.
+	if opts.SiteName != "" {
+		drawText(img, smallFace, siteNameColor, margin, Height-margin, opts.SiteName)
+	}
+
+	if err := png.Encode(w, img); err != nil {
+		return xerrors.Errorf("error encoding card: %w", err)
+	}
+
+	return nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Private
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// drawBackground scales and crops the background so that it fills the card
+// completely, then darkens it with a translucent overlay.
+func drawBackground(dst *image.RGBA, background image.Image) {
+	src := background.Bounds()
+
+	// Crop the source to the card's aspect ratio around its center.
+	crop := src
+	if src.Dx()*Height > src.Dy()*Width {
+		w := src.Dy() * Width / Height
+		crop.Min.X = src.Min.X + (src.Dx()-w)/2
+		crop.Max.X = crop.Min.X + w
+	} else {
+		h := src.Dx() * Height / Width
+		crop.Min.Y = src.Min.Y + (src.Dy()-h)/2
+		crop.Max.Y = crop.Min.Y + h
+	}
+
+	draw.CatmullRom.Scale(dst, dst.Bounds(), background, crop, draw.Over, nil)
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(overlayColor), image.Point{}, draw.Over)
+}
+
+func drawText(dst *image.RGBA, face font.Face, c color.Color, x, y int, s string) {
+	d := &font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(s)
+}
+
+func newFace(ttf []byte, size float64) (font.Face, error) {
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		return nil, xerrors.Errorf("error parsing font: %w", err)
+	}
+
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("error creating font face: %w", err)
+	}
+
+	return face, nil
+}
+
+// wrapText breaks text into lines that fit within the given width, truncating
+// with an ellipsis if there would be more than maxLines of them.
+func wrapText(face font.Face, s string, width, maxLines int) []string {
+	var lines []string
+	var line string
+
+	for _, word := range strings.Fields(s) {
+		candidate := word
+		if line != "" {
+			candidate = line + " " + word
+		}
+
+		if line != "" && font.MeasureString(face, candidate).Ceil() > width {
+			lines = append(lines, line)
+			line = word
+			continue
+		}
+
+		line = candidate
+	}
+
+	if line != "" {
+		lines = append(lines, line)
+	}
+
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+		lines[maxLines-1] += "…"
+	}
+
+	return lines
+}
@@ -0,0 +1,142 @@
+package ucard
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+
+	"github.com/brandur/mutelight/internal/utesting"
+)
+
+func TestOptionsHash(t *testing.T) {
+	opts := Options{
+		BackgroundHash: "abc",
+		SiteName:       "mutelight.org",
+		Subtitle:       "March 14, 2011",
+		Title:          "First Article",
+	}
+
+	// The hash is stable between runs so that cached cards can be reused by
+	// later builds. Changing it invalidates every cached card, so it should
+	// only happen along with a change to Version.
+	utesting.AssertEqual(t, "e5088d5e334634ceaa7e7b9aecb731235583f56487f163dad2c5c0d02d29f954", opts.Hash())
+
+	// Changing anything that appears on the card changes the hash so that
+	// the card is rendered again.
+	for name, change := range map[string]func(o *Options){
+		"BackgroundHash": func(o *Options) { o.BackgroundHash = "def" },
+		"SiteName":       func(o *Options) { o.SiteName = "brandur.org" },
+		"Subtitle":       func(o *Options) { o.Subtitle = "March 15, 2011" },
+		"Title":          func(o *Options) { o.Title = "Second Article" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			changed := opts
+			change(&changed)
+			if changed.Hash() == opts.Hash() {
+				t.Errorf("expected hash to change with %s", name)
+			}
+		})
+	}
+
+	// The background image itself isn't hashed because it's represented by
+	// BackgroundHash.
+	t.Run("Background", func(t *testing.T) {
+		changed := opts
+		changed.Background = image.NewRGBA(image.Rect(0, 0, 1, 1))
+		utesting.AssertEqual(t, opts.Hash(), changed.Hash())
+	})
+
+	// Text moving between fields changes the hash even though the
+	// concatenation of fields stays the same.
+	t.Run("FieldBoundaries", func(t *testing.T) {
+		a := &Options{Subtitle: "ab", Title: "c"}
+		b := &Options{Subtitle: "a", Title: "bc"}
+		if a.Hash() == b.Hash() {
+			t.Errorf("expected different hashes for text in different fields")
+		}
+	})
+}
+
+func TestRender(t *testing.T) {
+	var buf bytes.Buffer
+	err := Render(&buf, &Options{
+		Background: image.NewRGBA(image.Rect(0, 0, 400, 300)),
+		SiteName:   "mutelight.org",
+		Subtitle:   "March 14, 2011",
+		Title:      "First Article",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utesting.AssertEqual(t, image.Rect(0, 0, Width, Height), img.Bounds())
+}
+
+func TestWrapText(t *testing.T) {
+	face, err := newFace(goregular.TTF, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer face.Close()
+
+	const width = 300
+
+	t.Run("Short", func(t *testing.T) {
+		utesting.AssertEqual(t, []string{"First Article"}, wrapText(face, "First Article", width, 4))
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		utesting.AssertEqual(t, []string(nil), wrapText(face, "  ", width, 4))
+	})
+
+	t.Run("Wrapped", func(t *testing.T) {
+		title := "The quick brown fox jumps over the lazy dog"
+		lines := wrapText(face, title, width, 4)
+
+		if len(lines) < 2 {
+			t.Fatalf("expected title to wrap, got %v", lines)
+		}
+		for _, line := range lines {
+			assertFits(t, face, line, width)
+		}
+
+		// No words are lost or reordered.
+		utesting.AssertEqual(t, title, strings.Join(lines, " "))
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		title := strings.Repeat("word ", 50)
+		lines := wrapText(face, title, width, 3)
+
+		utesting.AssertEqual(t, 3, len(lines))
+		if !strings.HasSuffix(lines[2], "…") {
+			t.Errorf("expected last line to end with an ellipsis, got %q", lines[2])
+		}
+		for _, line := range lines[:2] {
+			assertFits(t, face, line, width)
+		}
+	})
+
+	// A word that's wider than the card can't be broken, so it gets a line
+	// of its own.
+	t.Run("LongWord", func(t *testing.T) {
+		word := strings.Repeat("a", 40)
+		utesting.AssertEqual(t, []string{"A", word, "B"}, wrapText(face, "A "+word+" B", width, 4))
+	})
+}
+
+func assertFits(t *testing.T, face font.Face, line string, width int) {
+	t.Helper()
+	if w := font.MeasureString(face, line).Ceil(); w > width {
+		t.Errorf("expected line %q to fit in %d pixels, but it's %d", line, width, w)
+	}
+}
@@ -27,15 +27,30 @@ const (
 	// AtomTag is a stable constant to use in Atom tags.
 	AtomTag = "mutelight.org"
 
+	// DefaultImage is the image used in OpenGraph and Twitter card metadata
+	// for pages that don't have a more specific image of their own.
+	DefaultImage = "/assets/images/icon.png"
+
 	// LayoutsDir is the source directory for view layouts.
 	LayoutsDir = "./layouts"
 
 	// MainLayout is the site's main layout.
 	MainLayout = LayoutsDir + "/main.ace"
 
+	// SiteDescription is the description used in metadata for pages that
+	// don't have a more specific description of their own.
+	SiteDescription = "Articles on software and technology by Brandur Leach."
+
+	// SiteName is the name of the site as it should appear in metadata.
+	SiteName = "Mutelight"
+
 	// TitleSuffix is the suffix to add to the end of page and Atom titles.
 	TitleSuffix = " — mutelight.org"
 
+	// TwitterHandle is the Twitter account attributed in Twitter card
+	// metadata.
+	TwitterHandle = "@brandur"
+
 	// ViewsDir is the source directory for views.
 	ViewsDir = "./views"
 )
@@ -50,6 +65,14 @@ const (
 //
 //////////////////////////////////////////////////////////////////////////////
 
+// CleanPath normalizes the path of a source file so that the same file is
+// always referred to by the same path, like `content/articles/a.md` for
+// `./content/articles/a.md`. Paths are compared this way when tracking which
+// sources changed, and displayed this way in the build manifest.
+func CleanPath(p string) string {
+	return filepath.ToSlash(filepath.Clean(p))
+}
+
 // ExitWithError prints the given error to stderr and exits with a status of 1.
 func ExitWithError(err error) {
 	fmt.Fprintf(os.Stderr, "error: %v\n", err)
@@ -0,0 +1,37 @@
+package ucommon
+
+import (
+	"testing"
+)
+
+func TestCleanPath(t *testing.T) {
+	for _, tc := range []struct {
+		source string
+		clean  string
+	}{
+		{"./content/articles/first-article.md", "content/articles/first-article.md"},
+		{"content/articles/first-article.md", "content/articles/first-article.md"},
+		{"./layouts/../views/index.ace", "views/index.ace"},
+		{"/tmp/site/./content", "/tmp/site/content"},
+	} {
+		if clean := CleanPath(tc.source); clean != tc.clean {
+			t.Errorf("expected clean path '%s' for '%s', got '%s'", tc.clean, tc.source, clean)
+		}
+	}
+}
+
+func TestExtractSlug(t *testing.T) {
+	for _, tc := range []struct {
+		source string
+		slug   string
+	}{
+		{"content/articles/first-article.md", "first-article"},
+		{"first-article.md", "first-article"},
+		{"content/articles/first-article", "first-article"},
+		{"content/articles/v1.2-release.md", "v1.2-release"},
+	} {
+		if slug := ExtractSlug(tc.source); slug != tc.slug {
+			t.Errorf("expected slug '%s' for '%s', got '%s'", tc.slug, tc.source, slug)
+		}
+	}
+}
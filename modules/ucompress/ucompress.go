@@ -0,0 +1,181 @@
+// Package ucompress produces precompressed gzip and Brotli siblings of build
+// outputs so that they can be served compressed without depending on a CDN
+// or web server to compress them on the fly.
+package ucompress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/mutelight/modules/urules"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Constants
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+const (
+	// BrotliExt is the extension of a Brotli compressed sibling.
+	BrotliExt = ".br"
+
+	// GzipExt is the extension of a gzip compressed sibling.
+	GzipExt = ".gz"
+)
+
+// MinSize is the size in bytes below which files aren't compressed because
+// any savings would be lost in the overhead of compression.
+const MinSize = 512
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Functions
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Compress writes gzip and Brotli siblings of source, like `index.html.gz`
+// and `index.html.br`. Siblings are given the same modification time as
+// source so that they're only written again if source changes. Returns
+// whether any work was done.
+func Compress(source string) (bool, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return false, xerrors.Errorf("error stating file '%s': %w", source, err)
+	}
+
+	if UpToDate(source+GzipExt, info) && UpToDate(source+BrotliExt, info) {
+		return false, nil
+	}
+
+	data, err := ioutil.ReadFile(source)
+	if err != nil {
+		return true, xerrors.Errorf("error reading file '%s': %w", source, err)
+	}
+
+	err = writeCompressed(source+GzipExt, data, info, func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(w, gzip.BestCompression)
+	})
+	if err != nil {
+		return true, err
+	}
+
+	err = writeCompressed(source+BrotliExt, data, info, func(w io.Writer) (io.WriteCloser, error) {
+		return brotli.NewWriterLevel(w, brotli.BestCompression), nil
+	})
+	if err != nil {
+		return true, err
+	}
+
+	return true, nil
+}
+
+// FindCompressible walks dir and returns the paths of files that should be
+// compressed according to urules. Symlinks aren't followed because they
+// point back into the source directory, which shouldn't have compressed
+// siblings written into it, so directories that should be compressed must
+// be copied into dir instead.
+func FindCompressible(dir string) ([]string, error) {
+	var sources []string
+
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() || info.Size() < MinSize {
+			return nil
+		}
+
+		// Files like Netlify's `_headers` and `_redirects` configure the host
+		// and are never served.
+		if strings.HasPrefix(info.Name(), "_") {
+			return nil
+		}
+
+		ext := filepath.Ext(p)
+		if ext == BrotliExt || ext == GzipExt {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		if !urules.Match(filepath.ToSlash(rel)).Compress {
+			return nil
+		}
+
+		sources = append(sources, p)
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("error walking directory '%s': %w", dir, err)
+	}
+
+	return sources, nil
+}
+
+// UpToDate checks whether the compressed sibling at target was produced from
+// the current version of the source file described by sourceInfo.
+func UpToDate(target string, sourceInfo os.FileInfo) bool {
+	info, err := os.Stat(target)
+	if err != nil {
+		return false
+	}
+	return info.ModTime().Equal(sourceInfo.ModTime())
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Private
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+func writeCompressed(target string, data []byte, sourceInfo os.FileInfo,
+	newWriter func(io.Writer) (io.WriteCloser, error)) error {
+	var buf bytes.Buffer
+
+	w, err := newWriter(&buf)
+	if err != nil {
+		return xerrors.Errorf("error creating writer for '%s': %w", target, err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return xerrors.Errorf("error compressing '%s': %w", target, err)
+	}
+	if err := w.Close(); err != nil {
+		return xerrors.Errorf("error compressing '%s': %w", target, err)
+	}
+
+	if err := ioutil.WriteFile(target, buf.Bytes(), 0o600); err != nil {
+		return xerrors.Errorf("error writing file '%s': %w", target, err)
+	}
+
+	modTime := sourceInfo.ModTime()
+	if err := os.Chtimes(target, modTime, modTime); err != nil {
+		return xerrors.Errorf("error setting times on '%s': %w", target, err)
+	}
+
+	return nil
+}
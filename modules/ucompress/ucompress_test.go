@@ -0,0 +1,131 @@
+package ucompress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestCompress(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "index.html")
+	data := []byte(strings.Repeat("<p>Hello, world.</p>\n", 100))
+
+	if err := ioutil.WriteFile(source, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	executed, err := Compress(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !executed {
+		t.Errorf("expected compression to execute")
+	}
+
+	gzipReader, err := gzip.NewReader(mustOpen(t, source+GzipExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDecompressed(t, data, gzipReader)
+	assertDecompressed(t, data, brotli.NewReader(mustOpen(t, source+BrotliExt)))
+
+	// Nothing to do if the source hasn't changed.
+	executed, err = Compress(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if executed {
+		t.Errorf("expected compression not to execute for unchanged source")
+	}
+
+	// But siblings are written again when it has.
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(source, future, future); err != nil {
+		t.Fatal(err)
+	}
+	executed, err = Compress(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !executed {
+		t.Errorf("expected compression to execute for changed source")
+	}
+}
+
+func TestFindCompressible(t *testing.T) {
+	dir := t.TempDir()
+	large := []byte(strings.Repeat("a", MinSize))
+
+	for name, data := range map[string][]byte{
+		"article":            large,
+		"articles.atom":      large,
+		"articles.atom.gz":   large,
+		"articles.atom.br":   large,
+		"assets/image.png":   large,
+		"assets/search.json": large,
+		"small":              []byte("a"),
+	} {
+		target := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(target, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sources, err := FindCompressible(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		filepath.Join(dir, "article"),
+		filepath.Join(dir, "articles.atom"),
+		filepath.Join(dir, "assets/search.json"),
+	}
+	if !reflect.DeepEqual(expected, sources) {
+		t.Errorf("expected %v, got %v", expected, sources)
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Helpers
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+func assertDecompressed(t *testing.T, expected []byte, r io.Reader) {
+	t.Helper()
+
+	actual, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(expected, actual) {
+		t.Errorf("decompressed data doesn't match source")
+	}
+}
+
+func mustOpen(t *testing.T, name string) *os.File {
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
@@ -0,0 +1,298 @@
+// Package uhosting post-processes a built site for hosts other than the S3
+// and CloudFront setup that its layout is tuned for, like by generating
+// configuration for the content types and cache headers in urules, which
+// `make deploy` would otherwise set.
+package uhosting
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/mutelight/modules/umanifest"
+	"github.com/brandur/mutelight/modules/uredirect"
+	"github.com/brandur/mutelight/modules/urules"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Constants
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+const (
+	// TargetGitHubPages gives HTML pages an `.html` extension so that GitHub
+	// Pages serves them with the right content type. GitHub Pages resolves
+	// extensionless paths to `.html` files, so URLs don't change.
+	TargetGitHubPages = "github-pages"
+
+	// TargetNetlify produces a Netlify `_headers` file with content types and
+	// cache headers for every output.
+	TargetNetlify = "netlify"
+
+	// TargetNginx produces `nginx.conf`, which contains a server block with
+	// content types, cache headers, and redirects.
+	TargetNginx = "nginx"
+
+	// TargetS3 is the default target, which is deployed to S3 and CloudFront
+	// with `make deploy`. No post-processing is done.
+	TargetS3 = "s3"
+)
+
+const (
+	// NetlifyHeadersFilename is the name of the file produced by
+	// TargetNetlify.
+	NetlifyHeadersFilename = "_headers"
+
+	// NginxFilename is the name of the file produced by TargetNginx.
+	NginxFilename = "nginx.conf"
+)
+
+// Names of files that configure hosts rather than being served.
+var configFilenames = map[string]bool{
+	NetlifyHeadersFilename:    true,
+	NginxFilename:             true,
+	umanifest.Filename:        true,
+	uredirect.NetlifyFilename: true,
+	uredirect.NginxFilename:   true,
+	uredirect.S3Filename:      true,
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Types
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Options are options for Apply.
+type Options struct {
+	// AbsoluteURL is the URL where the site will be hosted. Its host is used
+	// as the nginx server name.
+	AbsoluteURL string
+
+	// NginxRoot is the directory that nginx serves the site from.
+	NginxRoot string
+
+	// Redirects are the site's redirects.
+	Redirects []*uredirect.Redirect
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Functions
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Apply post-processes the site built in dir for the given target.
+func Apply(target, dir string, opts *Options) error {
+	switch target {
+	case TargetGitHubPages:
+		return applyGitHubPages(dir)
+	case TargetNetlify:
+		return applyNetlify(dir)
+	case TargetNginx:
+		return applyNginx(dir, opts)
+	case TargetS3:
+		return nil
+	}
+	return xerrors.Errorf("unknown hosting target: %q", target)
+}
+
+// CopiesAssets returns whether the given target needs assets to be copied
+// into the target directory rather than symlinked, which is the case for
+// every target that isn't deployed with `make deploy`.
+func CopiesAssets(target string) bool {
+	return target != TargetS3
+}
+
+// IsConfigFile checks whether the output at path p configures a host rather
+// than being served.
+func IsConfigFile(p string) bool {
+	return configFilenames[path.Base(p)]
+}
+
+// RedirectFormat returns the redirect format that the given target uses. S3
+// supports more than one, so configured is returned for it.
+func RedirectFormat(target, configured string) string {
+	switch target {
+	case TargetGitHubPages:
+		return uredirect.FormatHTML
+	case TargetNetlify:
+		return uredirect.FormatNetlify
+	case TargetNginx:
+		return uredirect.FormatNginx
+	}
+	return configured
+}
+
+// Validate checks that the target is one that's supported.
+func Validate(target string) error {
+	switch target {
+	case TargetGitHubPages, TargetNetlify, TargetNginx, TargetS3:
+		return nil
+	}
+	return xerrors.Errorf("unknown hosting target: %q", target)
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Private
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+func applyGitHubPages(dir string) error {
+	outputs, err := listOutputs(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range outputs {
+		// Go by content type rather than by whether there's an extension
+		// because slugs like `v1.2-release` look like they have one.
+		if urules.Match(p).ContentType != "text/html" || path.Ext(p) == ".html" {
+			continue
+		}
+
+		filename := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.Rename(filename, filename+".html"); err != nil {
+			return xerrors.Errorf("error renaming '%s': %w", filename, err)
+		}
+	}
+
+	return nil
+}
+
+func applyNetlify(dir string) error {
+	outputs, err := listOutputs(dir)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	for _, p := range outputs {
+		result := urules.Match(p)
+		fmt.Fprintf(&buf, "%s\n", p)
+		if result.CacheControl != "" {
+			fmt.Fprintf(&buf, "  Cache-Control: %s\n", result.CacheControl)
+		}
+		fmt.Fprintf(&buf, "  Content-Type: %s\n", result.ContentType)
+	}
+
+	return writeFile(filepath.Join(dir, NetlifyHeadersFilename), buf.Bytes())
+}
+
+func applyNginx(dir string, opts *Options) error {
+	serverName := strings.TrimPrefix(strings.TrimPrefix(opts.AbsoluteURL, "https://"), "http://")
+	serverName = strings.TrimSuffix(serverName, "/")
+
+	var buf bytes.Buffer
+
+	buf.WriteString("# Generated by mutelight. Include this file from nginx's http block.\n\n")
+
+	if err := uredirect.Write(&buf, uredirect.FormatNginx, opts.Redirects); err != nil {
+		return err
+	}
+
+	// Cache headers are set with a map so that nginx matches outputs with the
+	// same rules (and in the same order) as everything else.
+	buf.WriteString("\nmap $uri $mutelight_cache_control {\n")
+	for _, rule := range urules.Rules {
+		if rule.CacheControl == "" {
+			continue
+		}
+		fmt.Fprintf(&buf, "    \"~%s\" \"%s\";\n", rule.Regexp(), rule.CacheControl)
+	}
+	buf.WriteString("}\n")
+
+	fmt.Fprintf(&buf, `
+server {
+    listen 80;
+    server_name %s;
+    root %s;
+
+    # Pages don't have extensions, so anything that isn't otherwise typed is
+    # HTML.
+    default_type %s;
+    types {
+`, serverName, opts.NginxRoot, urules.Match("/index").ContentType)
+
+	exts := urules.Extensions()
+	var extList []string
+	for ext := range exts {
+		extList = append(extList, ext)
+	}
+	sort.Strings(extList)
+	for _, ext := range extList {
+		fmt.Fprintf(&buf, "        %s %s;\n", exts[ext], strings.TrimPrefix(ext, "."))
+	}
+
+	buf.WriteString(`    }
+
+    # Serve gzip'ed siblings produced by the build where they exist.
+    gzip_static on;
+
+    error_page 404 /404.html;
+
+    if ($mutelight_redirect) {
+        return 301 $mutelight_redirect;
+    }
+
+    location / {
+        add_header Cache-Control $mutelight_cache_control;
+        try_files $uri $uri/index.html =404;
+    }
+}
+`)
+
+	return writeFile(filepath.Join(dir, NginxFilename), buf.Bytes())
+}
+
+// listOutputs lists served outputs in dir as sorted paths like `/index.html`.
+// Host configuration files and precompressed siblings are skipped.
+func listOutputs(dir string) ([]string, error) {
+	manifest, err := umanifest.Build(dir, func(string) []string { return nil })
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs []string
+	for _, output := range manifest.Outputs {
+		if IsConfigFile(output.Path) {
+			continue
+		}
+		outputs = append(outputs, output.Path)
+	}
+
+	sort.Strings(outputs)
+	return outputs, nil
+}
+
+func writeFile(filename string, data []byte) error {
+	if err := ioutil.WriteFile(filename, data, 0o600); err != nil {
+		return xerrors.Errorf("error writing file '%s': %w", filename, err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package uhosting
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/brandur/mutelight/internal/utesting"
+	"github.com/brandur/mutelight/modules/uredirect"
+)
+
+func TestApplyGitHubPages(t *testing.T) {
+	dir := t.TempDir()
+	utesting.WriteFile(t, filepath.Join(dir, "first-article"), "article")
+	utesting.WriteFile(t, filepath.Join(dir, "v1.2-release"), "article")
+	utesting.WriteFile(t, filepath.Join(dir, "archive/index.html"), "archive")
+	utesting.WriteFile(t, filepath.Join(dir, "articles.atom"), "feed")
+	utesting.WriteFile(t, filepath.Join(dir, uredirect.NetlifyFilename), "redirects")
+
+	if err := Apply(TargetGitHubPages, dir, &Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	outputs, err := listOutputs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"/archive/index.html", "/articles.atom", "/first-article.html", "/v1.2-release.html"}
+	if !reflect.DeepEqual(expected, outputs) {
+		t.Errorf("expected %v, got %v", expected, outputs)
+	}
+}
+
+func TestApplyNetlify(t *testing.T) {
+	dir := t.TempDir()
+	utesting.WriteFile(t, filepath.Join(dir, "first-article"), "article")
+	utesting.WriteFile(t, filepath.Join(dir, "assets/1/main.css"), "css")
+	utesting.WriteFile(t, filepath.Join(dir, "assets/search.json"), "{}")
+
+	if err := Apply(TargetNetlify, dir, &Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, NetlifyHeadersFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Join([]string{
+		"/assets/1/main.css",
+		"  Cache-Control: public, max-age=86400",
+		"  Content-Type: text/css",
+		"/assets/search.json",
+		"  Cache-Control: public, max-age=3600",
+		"  Content-Type: application/json",
+		"/first-article",
+		"  Cache-Control: public, max-age=3600",
+		"  Content-Type: text/html",
+		"",
+	}, "\n")
+	if expected != string(data) {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, data)
+	}
+}
+
+func TestApplyNginx(t *testing.T) {
+	dir := t.TempDir()
+
+	err := Apply(TargetNginx, dir, &Options{
+		AbsoluteURL: "https://mutelight.org",
+		NginxRoot:   "/var/www/mutelight",
+		Redirects:   []*uredirect.Redirect{{From: "/a/1", To: "/first-article"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, NginxFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"    /a/1 /first-article;\n",
+		"    server_name mutelight.org;\n",
+		"    root /var/www/mutelight;\n",
+		"    error_page 404 /404.html;\n",
+		"    \"~^/assets/.*$\" \"public, max-age=86400\";\n",
+		"        text/css css;\n",
+		"        add_header Cache-Control $mutelight_cache_control;\n",
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected nginx configuration to contain %q:\n%s", expected, data)
+		}
+	}
+}
+
+func TestRedirectFormat(t *testing.T) {
+	if format := RedirectFormat(TargetS3, uredirect.FormatS3); format != uredirect.FormatS3 {
+		t.Errorf("expected configured format for S3, got %q", format)
+	}
+	if format := RedirectFormat(TargetNetlify, uredirect.FormatHTML); format != uredirect.FormatNetlify {
+		t.Errorf("expected Netlify format for Netlify, got %q", format)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate(TargetNetlify); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if err := Validate("geocities"); err == nil {
+		t.Errorf("expected error for unknown target")
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Helpers
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
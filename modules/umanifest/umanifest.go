@@ -0,0 +1,280 @@
+// Package umanifest produces a manifest of every output of a build, and
+// compares manifests so that it's possible to see what a build (and by
+// extension, a deploy) will change.
+package umanifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/mutelight/modules/urules"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Constants
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Filename is the name of the manifest file in the target directory.
+const Filename = "manifest.json"
+
+// Extensions of files which are derived from other outputs and therefore
+// aren't included in manifests.
+var derivedExts = map[string]bool{
+	".br": true,
+	".gz": true,
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Types
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Diff is the difference between two manifests.
+type Diff struct {
+	// Added are paths of outputs in the new manifest but not the old.
+	Added []string
+
+	// Changed are paths of outputs in both manifests whose contents differ.
+	Changed []string
+
+	// Removed are paths of outputs in the old manifest but not the new.
+	Removed []string
+}
+
+// Empty checks whether there are no differences.
+func (d *Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Changed) == 0 && len(d.Removed) == 0
+}
+
+// Manifest lists every output of a build.
+type Manifest struct {
+	// Outputs are the build's outputs sorted by path.
+	Outputs []*Output `json:"outputs"`
+}
+
+// Write encodes the manifest to w.
+func (m *Manifest) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(m); err != nil {
+		return xerrors.Errorf("error encoding manifest: %w", err)
+	}
+	return nil
+}
+
+// Output is a single file produced by a build.
+type Output struct {
+	// ContentType is the output's content type, like `text/html`.
+	ContentType string `json:"content_type"`
+
+	// Path is the output's path relative to the target directory, like
+	// `/index.html`.
+	Path string `json:"path"`
+
+	// SHA256 is a hex-encoded SHA-256 digest of the output's contents.
+	SHA256 string `json:"sha256"`
+
+	// Size is the size of the output in bytes.
+	Size int64 `json:"size"`
+
+	// Sources are the paths of sources that produced the output. Outputs that
+	// are built from whole directories of content, like indexes and feeds,
+	// list the directory.
+	Sources []string `json:"sources"`
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Functions
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Build produces a manifest of every output in targetDir. Symlinks are
+// followed so that assets linked in from the source directory are included.
+// sources is invoked with each output's path to get the sources that
+// produced it.
+func Build(targetDir string, sources func(p string) []string) (*Manifest, error) {
+	manifest := &Manifest{Outputs: []*Output{}}
+
+	err := walk(targetDir, "/", func(filename, p string) error {
+		if p == "/"+Filename || derivedExts[path.Ext(p)] {
+			return nil
+		}
+
+		output, err := newOutput(filename, p)
+		if err != nil {
+			return err
+		}
+
+		output.Sources = sources(p)
+		if output.Sources == nil {
+			output.Sources = []string{}
+		}
+
+		manifest.Outputs = append(manifest.Outputs, output)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(manifest.Outputs, func(i, j int) bool {
+		return manifest.Outputs[i].Path < manifest.Outputs[j].Path
+	})
+
+	return manifest, nil
+}
+
+// Compare produces the differences between an old and new manifest.
+func Compare(oldManifest, newManifest *Manifest) *Diff {
+	oldOutputs := make(map[string]*Output, len(oldManifest.Outputs))
+	for _, output := range oldManifest.Outputs {
+		oldOutputs[output.Path] = output
+	}
+
+	newOutputs := make(map[string]*Output, len(newManifest.Outputs))
+	for _, output := range newManifest.Outputs {
+		newOutputs[output.Path] = output
+	}
+
+	diff := &Diff{}
+
+	for _, output := range newManifest.Outputs {
+		oldOutput, ok := oldOutputs[output.Path]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, output.Path)
+		case oldOutput.SHA256 != output.SHA256:
+			diff.Changed = append(diff.Changed, output.Path)
+		}
+	}
+
+	for _, output := range oldManifest.Outputs {
+		if _, ok := newOutputs[output.Path]; !ok {
+			diff.Removed = append(diff.Removed, output.Path)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Changed)
+	sort.Strings(diff.Removed)
+
+	return diff
+}
+
+// ContentType gets the content type of the output at the given path as
+// determined by urules.
+func ContentType(p string) string {
+	return urules.Match(p).ContentType
+}
+
+// Read reads a manifest from the given file.
+func Read(filename string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, xerrors.Errorf("error reading manifest '%s': %w", filename, err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, xerrors.Errorf("error decoding manifest '%s': %w", filename, err)
+	}
+
+	return &manifest, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Private
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+func newOutput(filename, p string) (*Output, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, xerrors.Errorf("error opening file '%s': %w", filename, err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return nil, xerrors.Errorf("error reading file '%s': %w", filename, err)
+	}
+
+	return &Output{
+		ContentType: ContentType(p),
+		Path:        p,
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+		Size:        size,
+	}, nil
+}
+
+// walk invokes fn with every file under dir along with its path relative to
+// the root of the walk. Unlike filepath.Walk, symlinks are followed.
+func walk(dir, p string, fn func(filename, p string) error) error {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return xerrors.Errorf("error reading directory '%s': %w", dir, err)
+	}
+
+	for _, info := range infos {
+		filename := filepath.Join(dir, info.Name())
+		childPath := path.Join(p, info.Name())
+
+		// ReadDir doesn't follow symlinks, so stat again to find out what
+		// they point to.
+		if info.Mode()&os.ModeSymlink != 0 {
+			info, err = os.Stat(filename)
+			if err != nil {
+				return xerrors.Errorf("error stating file '%s': %w", filename, err)
+			}
+		}
+
+		if info.IsDir() {
+			if err := walk(filename, childPath, fn); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+
+		if err := fn(filename, childPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
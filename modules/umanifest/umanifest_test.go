@@ -0,0 +1,142 @@
+package umanifest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/brandur/mutelight/internal/utesting"
+)
+
+func TestBuild(t *testing.T) {
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+
+	utesting.WriteFile(t, filepath.Join(sourceDir, "images/icon.png"), "png")
+	utesting.WriteFile(t, filepath.Join(targetDir, "first-article"), "<p>First</p>")
+	utesting.WriteFile(t, filepath.Join(targetDir, "first-article.gz"), "compressed")
+	utesting.WriteFile(t, filepath.Join(targetDir, "articles.atom"), "<feed />")
+	utesting.WriteFile(t, filepath.Join(targetDir, Filename), "{}")
+
+	if err := os.MkdirAll(filepath.Join(targetDir, "assets"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	err := os.Symlink(filepath.Join(sourceDir, "images"), filepath.Join(targetDir, "assets/images"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := Build(targetDir, func(p string) []string {
+		if p == "/first-article" {
+			return []string{"content/articles/first-article.md"}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Manifest{Outputs: []*Output{
+		{
+			ContentType: "application/xml",
+			Path:        "/articles.atom",
+			SHA256:      digest("<feed />"),
+			Size:        8,
+			Sources:     []string{},
+		},
+		{
+			ContentType: "image/png",
+			Path:        "/assets/images/icon.png",
+			SHA256:      digest("png"),
+			Size:        3,
+			Sources:     []string{},
+		},
+		{
+			ContentType: "text/html",
+			Path:        "/first-article",
+			SHA256:      digest("<p>First</p>"),
+			Size:        12,
+			Sources:     []string{"content/articles/first-article.md"},
+		},
+	}}
+
+	if !reflect.DeepEqual(expected, manifest) {
+		t.Errorf("expected %+v, got %+v", expected.Outputs, manifest.Outputs)
+	}
+
+	// Writing and reading back a manifest produces the same manifest.
+	var buf bytes.Buffer
+	if err := manifest.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), Filename)
+	utesting.WriteFile(t, filename, buf.String())
+
+	read, err := Read(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(manifest, read) {
+		t.Errorf("expected %+v, got %+v", manifest.Outputs, read.Outputs)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	oldManifest := &Manifest{Outputs: []*Output{
+		{Path: "/changed", SHA256: "a"},
+		{Path: "/removed", SHA256: "a"},
+		{Path: "/unchanged", SHA256: "a"},
+	}}
+	newManifest := &Manifest{Outputs: []*Output{
+		{Path: "/added", SHA256: "a"},
+		{Path: "/changed", SHA256: "b"},
+		{Path: "/unchanged", SHA256: "a"},
+	}}
+
+	diff := Compare(oldManifest, newManifest)
+	expected := &Diff{
+		Added:   []string{"/added"},
+		Changed: []string{"/changed"},
+		Removed: []string{"/removed"},
+	}
+	if !reflect.DeepEqual(expected, diff) {
+		t.Errorf("expected %+v, got %+v", expected, diff)
+	}
+
+	if !Compare(newManifest, newManifest).Empty() {
+		t.Errorf("expected no differences comparing a manifest to itself")
+	}
+}
+
+func TestContentType(t *testing.T) {
+	for p, contentType := range map[string]string{
+		"/articles.atom":          "application/xml",
+		"/assets/search.json":     "application/json",
+		"/first-article":          "text/html",
+		"/fragments/index.html":   "text/html",
+		"/assets/unknown.unknown": "application/octet-stream",
+	} {
+		if actual := ContentType(p); actual != contentType {
+			t.Errorf("expected content type '%s' for '%s', got '%s'", contentType, p, actual)
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Helpers
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+func digest(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,337 @@
+// Package uoverlay shows build errors in the browser during development. A
+// Hub pushes the errors of the latest build to pages over a websocket, and a
+// script injected into each page renders them in an overlay that clears
+// itself once a build succeeds again.
+//
+// The script also takes over live reload from Modulir's own script so that
+// builds in which only stylesheets changed can swap them into the page in
+// place instead of reloading it.
+package uoverlay
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/xerrors"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Constants
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Path is the path at which a Hub should be served.
+const Path = "/_mutelight/errors"
+
+const (
+	// ReloadNone leaves pages as they are.
+	ReloadNone = ""
+
+	// ReloadPage reloads pages.
+	ReloadPage = "page"
+
+	// ReloadStylesheets reloads only the stylesheets linked from pages,
+	// which keeps their scroll position and any other state.
+	ReloadStylesheets = "stylesheets"
+)
+
+// How long to wait on a slow client before giving up on a write.
+const writeTimeout = 5 * time.Second
+
+// Matches a quoted path in an error message, which is how errors throughout
+// the build refer to the files they're about.
+var quotedPathRE = regexp.MustCompile(`'([^'\s]+)'`)
+
+// Script renders the overlay. It's injected into pages by Inject.
+const Script = `<script>
+(function() {
+  var overlay = null;
+
+  function render(errors) {
+    if (overlay) {
+      overlay.remove();
+      overlay = null;
+    }
+    if (!errors || errors.length === 0) {
+      return;
+    }
+
+    overlay = document.createElement("div");
+    overlay.id = "mutelight-error-overlay";
+    overlay.style.cssText = "position: fixed; inset: 0; z-index: 2147483647; " +
+      "overflow: auto; padding: 32px; background: rgba(20, 20, 20, 0.95); " +
+      "color: #eee; font: 14px/1.5 Menlo, Consolas, monospace;";
+
+    var heading = document.createElement("h1");
+    heading.style.cssText = "margin: 0 0 24px; color: #ff6b6b; font-size: 20px;";
+    heading.textContent = "Build failed (" + errors.length + " error" +
+      (errors.length === 1 ? "" : "s") + ")";
+    overlay.appendChild(heading);
+
+    errors.forEach(function(error) {
+      var section = document.createElement("div");
+      section.style.cssText = "margin-bottom: 24px;";
+
+      var title = document.createElement("div");
+      title.style.cssText = "color: #ffd166; font-weight: bold;";
+      title.textContent = error.job || "build";
+      section.appendChild(title);
+
+      if (error.source) {
+        var source = document.createElement("div");
+        source.style.cssText = "color: #aaa;";
+        source.textContent = error.source;
+        section.appendChild(source);
+      }
+
+      var message = document.createElement("pre");
+      message.style.cssText = "margin: 8px 0 0; white-space: pre-wrap;";
+      message.textContent = error.message;
+      section.appendChild(message);
+
+      overlay.appendChild(section);
+    });
+
+    document.body.appendChild(overlay);
+  }
+
+  // Stylesheets are reloaded by adding a copy of each link with a new query
+  // string and removing the original once the copy loads, which avoids a
+  // flash of unstyled content.
+  function reloadStylesheets() {
+    var links = document.querySelectorAll("link[rel=stylesheet]");
+    Array.prototype.forEach.call(links, function(link) {
+      var url = new URL(link.href);
+      if (url.host !== location.host) {
+        return;
+      }
+      url.searchParams.set("reload", Date.now());
+
+      var copy = link.cloneNode();
+      copy.href = url.toString();
+      copy.onload = function() {
+        link.remove();
+      };
+      link.parentNode.insertBefore(copy, link.nextSibling);
+    });
+  }
+
+  function connect() {
+    var protocol = location.protocol === "https:" ? "wss:" : "ws:";
+    var socket = new WebSocket(protocol + "//" + location.host + "` + Path + `");
+    socket.onmessage = function(event) {
+      var data = JSON.parse(event.data);
+      render(data.errors);
+      if (data.errors.length > 0) {
+        return;
+      }
+
+      if (data.reload === "` + ReloadPage + `") {
+        location.reload();
+      } else if (data.reload === "` + ReloadStylesheets + `") {
+        reloadStylesheets();
+      }
+    };
+    socket.onclose = function() {
+      setTimeout(connect, 1000);
+    };
+  }
+
+  connect();
+})();
+</script>
+`
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Types
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// BuildError is an error from a build as shown in the overlay.
+type BuildError struct {
+	// Job is the name of the job that failed, like `article: hello.md`. May
+	// be empty if the error didn't come from a job.
+	Job string `json:"job"`
+
+	// Message is the error's message.
+	Message string `json:"message"`
+
+	// Source is the path of the file that the error is about, like
+	// `content/articles/hello.md`. May be empty if it's not known.
+	Source string `json:"source"`
+}
+
+// NewBuildError produces a BuildError from an error returned by a job named
+// job. Source is the innermost file referred to by the error's message for
+// which exists returns true.
+func NewBuildError(job string, err error, exists func(p string) bool) *BuildError {
+	buildError := &BuildError{Job: job, Message: err.Error()}
+
+	matches := quotedPathRE.FindAllStringSubmatch(buildError.Message, -1)
+	for i := len(matches) - 1; i >= 0; i-- {
+		if exists(matches[i][1]) {
+			buildError.Source = matches[i][1]
+			break
+		}
+	}
+
+	return buildError
+}
+
+// Hub pushes the errors of the latest build to every connected page, along
+// with how pages should be reloaded.
+type Hub struct {
+	conns    map[*websocket.Conn]bool
+	errors   []*BuildError
+	mu       sync.Mutex
+	upgrader websocket.Upgrader
+}
+
+// NewHub produces a new Hub.
+func NewHub() *Hub {
+	return &Hub{
+		conns:  make(map[*websocket.Conn]bool),
+		errors: []*BuildError{},
+	}
+}
+
+// Publish sets the errors of the latest build and pushes them to every
+// connected page along with reload, which is one of ReloadNone, ReloadPage,
+// or ReloadStylesheets. Publishing no errors clears the overlay. Pages aren't
+// reloaded while there are errors.
+func (h *Hub) Publish(errors []*BuildError, reload string) {
+	if errors == nil {
+		errors = []*BuildError{}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.errors = errors
+	for conn := range h.conns {
+		if err := h.send(conn, reload); err != nil {
+			conn.Close()
+			delete(h.conns, conn)
+		}
+	}
+}
+
+// ServeHTTP upgrades a request to a websocket and pushes errors to it until
+// it disconnects. The errors of the latest build are sent right away so that
+// a page loaded after a failed build shows them too, but without reloading
+// the page.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already responded with an error.
+		return
+	}
+
+	h.mu.Lock()
+	if err := h.send(conn, ReloadNone); err != nil {
+		h.mu.Unlock()
+		conn.Close()
+		return
+	}
+	h.conns[conn] = true
+	h.mu.Unlock()
+
+	// Nothing is expected from the page, but reading is how a closed
+	// connection is detected.
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	h.mu.Lock()
+	delete(h.conns, conn)
+	h.mu.Unlock()
+	conn.Close()
+}
+
+// send writes the latest errors to conn. Must be called with mu held.
+func (h *Hub) send(conn *websocket.Conn, reload string) error {
+	data, err := json.Marshal(struct {
+		Errors []*BuildError `json:"errors"`
+		Reload string        `json:"reload"`
+	}{h.errors, reload})
+	if err != nil {
+		return xerrors.Errorf("error encoding build errors: %w", err)
+	}
+
+	if err := conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return xerrors.Errorf("error setting write deadline: %w", err)
+	}
+
+	if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		return xerrors.Errorf("error writing build errors: %w", err)
+	}
+
+	return nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Functions
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Inject adds Script to an HTML page right before its closing body tag, or
+// at the end of the page if it doesn't have one. Modulir's live reload script
+// is removed because Script replaces it, and having both would reload the
+// page even when only stylesheets changed.
+func Inject(page []byte) []byte {
+	page = removeLiveReload(page)
+
+	i := bytes.LastIndex(page, []byte("</body>"))
+	if i == -1 {
+		return append(page, Script...)
+	}
+
+	injected := make([]byte, 0, len(page)+len(Script))
+	injected = append(injected, page[:i]...)
+	injected = append(injected, Script...)
+	injected = append(injected, page[i:]...)
+	return injected
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Private
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// liveReloadScript is the tag that Modulir injects into the pages it serves
+// to load its live reload script.
+var liveReloadScript = []byte(`<script src="/websocket.js"></script>`)
+
+// removeLiveReload removes Modulir's live reload script from page. Only its
+// exact tag is removed so that the site's own scripts are left alone, even
+// if they happen to use a websocket.
+func removeLiveReload(page []byte) []byte {
+	return bytes.ReplaceAll(page, liveReloadScript, nil)
+}
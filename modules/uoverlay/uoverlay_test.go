@@ -0,0 +1,102 @@
+package uoverlay
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHub(t *testing.T) {
+	hub := NewHub()
+	hub.Publish([]*BuildError{{Job: "article: a.md", Message: "bad frontmatter"}}, ReloadPage)
+
+	server := httptest.NewServer(hub)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+Path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Errors from the latest build are sent on connect, but without asking
+	// for a reload.
+	errors, reload := readMessage(t, conn)
+	if len(errors) != 1 || errors[0].Job != "article: a.md" {
+		t.Errorf("expected one error from latest build, got %+v", errors)
+	}
+	if reload != ReloadNone {
+		t.Errorf("expected no reload on connect, got %q", reload)
+	}
+
+	// A successful build clears them.
+	hub.Publish(nil, ReloadStylesheets)
+	errors, reload = readMessage(t, conn)
+	if len(errors) != 0 {
+		t.Errorf("expected errors to be cleared, got %+v", errors)
+	}
+	if reload != ReloadStylesheets {
+		t.Errorf("expected stylesheets reload, got %q", reload)
+	}
+}
+
+func TestInject(t *testing.T) {
+	page := string(Inject([]byte("<html><body><p>hi</p></body></html>")))
+	if !strings.HasSuffix(page, Script+"</body></html>") {
+		t.Errorf("expected script before closing body tag, got %s", page)
+	}
+
+	page = string(Inject([]byte("<p>fragment</p>")))
+	if !strings.HasSuffix(page, Script) {
+		t.Errorf("expected script at end of page, got %s", page)
+	}
+
+	// Modulir's live reload script is replaced, but other scripts are left
+	// alone, including ones of the site's own that use a websocket.
+	const siteScripts = `<script src="/app.js"></script>` +
+		`<script>new WebSocket("wss://example.com/chat");</script>`
+	page = string(Inject([]byte(`<body>` + siteScripts +
+		`<script src="/websocket.js"></script></body>`)))
+	if page != `<body>`+siteScripts+Script+"</body>" {
+		t.Errorf("expected only live reload script to be removed, got %s", page)
+	}
+}
+
+func TestNewBuildError(t *testing.T) {
+	exists := func(p string) bool {
+		return p == "content/articles/a.md" || p == "views/_nav.ace"
+	}
+
+	buildError := NewBuildError("article: a.md",
+		errors.New("error rendering 'content/articles/a.md': error in 'views/_nav.ace': oops"), exists)
+	if buildError.Source != "views/_nav.ace" {
+		t.Errorf("expected innermost existing source, got %q", buildError.Source)
+	}
+
+	buildError = NewBuildError("search", errors.New("error in 'public/nope': oops"), exists)
+	if buildError.Source != "" {
+		t.Errorf("expected no source, got %q", buildError.Source)
+	}
+}
+
+func readMessage(t *testing.T, conn *websocket.Conn) ([]*BuildError, string) {
+	t.Helper()
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var message struct {
+		Errors []*BuildError `json:"errors"`
+		Reload string        `json:"reload"`
+	}
+	if err := json.Unmarshal(data, &message); err != nil {
+		t.Fatal(err)
+	}
+	return message.Errors, message.Reload
+}
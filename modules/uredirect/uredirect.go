@@ -0,0 +1,325 @@
+// Package uredirect produces redirects from old paths on the site to new
+// ones in a variety of formats suitable for different hosting setups.
+package uredirect
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Constants
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+const (
+	// FormatHTML produces an HTML stub at each redirect's source path that
+	// sends the browser along with a meta refresh. It works anywhere that can
+	// host static files.
+	FormatHTML = "html"
+
+	// FormatNetlify produces a Netlify `_redirects` file.
+	FormatNetlify = "netlify"
+
+	// FormatNginx produces an Nginx map file which can be included in a
+	// server's configuration.
+	FormatNginx = "nginx"
+
+	// FormatS3 produces HTML stubs like FormatHTML, but also a file listing
+	// redirects which is used on deploy to set S3's
+	// `x-amz-website-redirect-location` metadata on each stub so that S3
+	// issues a real redirect.
+	FormatS3 = "s3"
+)
+
+const (
+	// NetlifyFilename is the name of the file produced by FormatNetlify.
+	NetlifyFilename = "_redirects"
+
+	// NginxFilename is the name of the file produced by FormatNginx.
+	NginxFilename = "redirects.nginx.conf"
+
+	// S3Filename is the name of the file produced by FormatS3.
+	S3Filename = "s3-redirects.tsv"
+)
+
+// Formats lists every supported format.
+var Formats = []string{FormatHTML, FormatNetlify, FormatNginx, FormatS3}
+
+// Every stub produced by HTMLStub starts with this, which is how stubs are
+// told apart from other outputs.
+const stubPrefix = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Redirecting&hellip;</title>
+`
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Types
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Redirect is a redirect from one path on the site to another.
+type Redirect struct {
+	// From is the old path being redirected from, like `/a/xyz`.
+	From string
+
+	// To is the path being redirected to, like `/my-article`.
+	To string
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Functions
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Filename returns the name of the file that should be written for the given
+// format, or an empty string if the format doesn't use one.
+func Filename(format string) string {
+	switch format {
+	case FormatNetlify:
+		return NetlifyFilename
+	case FormatNginx:
+		return NginxFilename
+	case FormatS3:
+		return S3Filename
+	}
+	return ""
+}
+
+// HTMLStub produces an HTML page which redirects to the given path with a
+// meta refresh and which points to absoluteURL as its canonical URL so that
+// search engines attribute the page correctly.
+func HTMLStub(to, absoluteURL string) []byte {
+	to = html.EscapeString(to)
+	absoluteURL = html.EscapeString(absoluteURL)
+
+	return []byte(fmt.Sprintf(stubPrefix+`<link rel="canonical" href="%s">
+<meta name="robots" content="noindex">
+<meta http-equiv="refresh" content="0; url=%s">
+</head>
+<body>
+<p>This page has moved to <a href="%s">%s</a>.</p>
+</body>
+</html>
+`, absoluteURL, to, to, to))
+}
+
+// IsStub checks whether the file at filename is an HTML stub produced by
+// HTMLStub.
+func IsStub(filename string) (bool, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false, xerrors.Errorf("error opening file '%s': %w", filename, err)
+	}
+	defer f.Close()
+
+	prefix := make([]byte, len(stubPrefix))
+	if _, err := io.ReadFull(f, prefix); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		return false, xerrors.Errorf("error reading file '%s': %w", filename, err)
+	}
+
+	return bytes.Equal(prefix, []byte(stubPrefix)), nil
+}
+
+// NormalizePath makes sure that the given path has a leading slash and no
+// trailing one.
+func NormalizePath(p string) string {
+	return "/" + strings.Trim(p, "/")
+}
+
+// ReadS3 reads redirects from a file in the format produced by FormatS3.
+func ReadS3(r io.Reader) ([]*Redirect, error) {
+	var redirects []*Redirect
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		parts := strings.Split(line, "\t")
+		if len(parts) != 2 {
+			return nil, xerrors.Errorf("malformed redirect: %q", line)
+		}
+
+		redirects = append(redirects, &Redirect{From: parts[0], To: parts[1]})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, xerrors.Errorf("error reading redirects: %w", err)
+	}
+
+	return redirects, nil
+}
+
+// RemoveStaleStubs removes HTML stubs in dir that aren't for any of the
+// given redirects, like those for aliases that were since removed from an
+// article. Stubs are recognized by their content (see IsStub), so stubs left
+// behind by earlier runs are removed too.
+func RemoveStaleStubs(dir string, redirects []*Redirect) error {
+	current := make(map[string]bool)
+	for _, redirect := range redirects {
+		current[redirect.From] = true
+	}
+
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if current["/"+filepath.ToSlash(rel)] {
+			return nil
+		}
+
+		stub, err := IsStub(p)
+		if err != nil || !stub {
+			return err
+		}
+
+		if err := os.Remove(p); err != nil {
+			return xerrors.Errorf("error removing file '%s': %w", p, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return xerrors.Errorf("error removing stale stubs in '%s': %w", dir, err)
+	}
+
+	return nil
+}
+
+// UsesStubs returns whether the given format requires that HTML stubs be
+// written for each redirect.
+func UsesStubs(format string) bool {
+	return format == FormatHTML || format == FormatS3
+}
+
+// Validate checks that the format is one that's supported, that every path
+// redirected from is absolute and clean, and that no path is redirected from
+// more than once.
+//
+// Paths are written as-is into files and used to place stubs, so one that
+// isn't clean, like `/a/../../etc`, could write outside of the target
+// directory.
+func Validate(format string, redirects []*Redirect) error {
+	switch format {
+	case FormatHTML, FormatNetlify, FormatNginx, FormatS3:
+	default:
+		return xerrors.Errorf("unknown redirect format: %q", format)
+	}
+
+	seen := make(map[string]*Redirect)
+	for _, redirect := range redirects {
+		if !validPath(redirect.From) {
+			return xerrors.Errorf("invalid redirect from '%s' (to '%s'): must be an absolute, "+
+				"clean path without whitespace", redirect.From, redirect.To)
+		}
+
+		if other, ok := seen[redirect.From]; ok {
+			return xerrors.Errorf("duplicate redirect from '%s' (to '%s' and '%s')",
+				redirect.From, other.To, redirect.To)
+		}
+		seen[redirect.From] = redirect
+	}
+
+	return nil
+}
+
+// Write writes a file containing the given redirects in the given format.
+// Formats that don't use a file (see Filename) write nothing.
+func Write(w io.Writer, format string, redirects []*Redirect) error {
+	var err error
+	switch format {
+	case FormatNetlify:
+		for _, redirect := range redirects {
+			if _, err = fmt.Fprintf(w, "%s %s 301\n", redirect.From, redirect.To); err != nil {
+				break
+			}
+		}
+
+	case FormatNginx:
+		_, err = io.WriteString(w, "map $uri $mutelight_redirect {\n")
+		for _, redirect := range redirects {
+			if err != nil {
+				break
+			}
+			_, err = fmt.Fprintf(w, "    %s %s;\n", redirect.From, redirect.To)
+		}
+		if err == nil {
+			_, err = io.WriteString(w, "}\n")
+		}
+
+	case FormatS3:
+		for _, redirect := range redirects {
+			if _, err = fmt.Fprintf(w, "%s\t%s\n", redirect.From, redirect.To); err != nil {
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		return xerrors.Errorf("error writing redirects: %w", err)
+	}
+
+	return nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Private
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// validPath checks whether p is an absolute, clean path below the root that
+// can be safely redirected from. A clean absolute path can't contain `..`.
+// Whitespace isn't allowed because it separates fields in every redirects
+// file.
+func validPath(p string) bool {
+	if p == "/" || !strings.HasPrefix(p, "/") || path.Clean(p) != p {
+		return false
+	}
+
+	return !strings.ContainsAny(p, " \t\r\n")
+}
@@ -0,0 +1,196 @@
+package uredirect
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brandur/mutelight/internal/utesting"
+)
+
+var testRedirects = []*Redirect{
+	{From: "/a/1", To: "/first-article"},
+	{From: "/old/second-article", To: "/second-article"},
+}
+
+func TestIsStub(t *testing.T) {
+	dir := t.TempDir()
+
+	stub := filepath.Join(dir, "stub")
+	utesting.WriteFile(t, stub, string(HTMLStub("/first-article", "https://mutelight.org/first-article")))
+	assertStub(t, true, stub)
+
+	page := filepath.Join(dir, "page")
+	utesting.WriteFile(t, page, "<!DOCTYPE html>\n<html>\n<head>\n<title>First Article</title>\n")
+	assertStub(t, false, page)
+
+	empty := filepath.Join(dir, "empty")
+	utesting.WriteFile(t, empty, "")
+	assertStub(t, false, empty)
+}
+
+func TestReadS3(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatS3, testRedirects); err != nil {
+		t.Fatal(err)
+	}
+
+	redirects, err := ReadS3(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utesting.AssertEqual(t, testRedirects, redirects)
+
+	_, err = ReadS3(strings.NewReader("/a/1 /first-article\n"))
+	if err == nil || !strings.Contains(err.Error(), "malformed redirect") {
+		t.Errorf("expected malformed redirect error, got %v", err)
+	}
+}
+
+func TestRemoveStaleStubs(t *testing.T) {
+	dir := t.TempDir()
+	utesting.WriteFile(t, filepath.Join(dir, "a/1"), string(HTMLStub("/first-article", "")))
+	utesting.WriteFile(t, filepath.Join(dir, "a/2"), string(HTMLStub("/removed-alias", "")))
+	utesting.WriteFile(t, filepath.Join(dir, "first-article"), "<p>First article</p>")
+
+	err := RemoveStaleStubs(dir, []*Redirect{{From: "/a/1", To: "/first-article"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for p, expected := range map[string]bool{
+		"a/1":           true,
+		"a/2":           false,
+		"first-article": true,
+	} {
+		_, err := os.Stat(filepath.Join(dir, p))
+		if exists := err == nil; exists != expected {
+			t.Errorf("expected '%s' to exist: %v, but got: %v", p, expected, exists)
+		}
+	}
+
+	// Without any redirects, every stub is removed, but nothing else.
+	if err := RemoveStaleStubs(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a/1")); !os.IsNotExist(err) {
+		t.Errorf("expected stub to be removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "first-article")); err != nil {
+		t.Errorf("expected page to be kept, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, format := range Formats {
+		if err := Validate(format, testRedirects); err != nil {
+			t.Errorf("expected format %q to be valid, got %v", format, err)
+		}
+	}
+
+	testCases := []struct {
+		name      string
+		format    string
+		redirects []*Redirect
+		expected  string
+	}{
+		{
+			"UnknownFormat",
+			"apache",
+			testRedirects,
+			`unknown redirect format: "apache"`,
+		},
+		{
+			"Duplicate",
+			FormatHTML,
+			[]*Redirect{{From: "/a/1", To: "/first-article"}, {From: "/a/1", To: "/second-article"}},
+			"duplicate redirect from '/a/1' (to '/first-article' and '/second-article')",
+		},
+		{
+			"ParentDirectory",
+			FormatHTML,
+			[]*Redirect{{From: "/../../etc/passwd", To: "/first-article"}},
+			"invalid redirect from '/../../etc/passwd'",
+		},
+		{
+			"ParentDirectoryInside",
+			FormatS3,
+			[]*Redirect{{From: "/a/../first-article", To: "/second-article"}},
+			"invalid redirect from '/a/../first-article'",
+		},
+		{
+			"Relative",
+			FormatNginx,
+			[]*Redirect{{From: "a/1", To: "/first-article"}},
+			"invalid redirect from 'a/1'",
+		},
+		{
+			"Root",
+			FormatNetlify,
+			[]*Redirect{{From: "/", To: "/first-article"}},
+			"invalid redirect from '/'",
+		},
+		{
+			"TrailingSlash",
+			FormatHTML,
+			[]*Redirect{{From: "/old/", To: "/first-article"}},
+			"invalid redirect from '/old/'",
+		},
+		{
+			"Whitespace",
+			FormatNetlify,
+			[]*Redirect{{From: "/old article", To: "/first-article"}},
+			"invalid redirect from '/old article'",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Validate(tc.format, tc.redirects)
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("expected error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	testCases := []struct {
+		format   string
+		expected string
+	}{
+		{FormatHTML, ""},
+		{FormatNetlify, "/a/1 /first-article 301\n" +
+			"/old/second-article /second-article 301\n"},
+		{FormatNginx, "map $uri $mutelight_redirect {\n" +
+			"    /a/1 /first-article;\n" +
+			"    /old/second-article /second-article;\n" +
+			"}\n"},
+		{FormatS3, "/a/1\t/first-article\n" +
+			"/old/second-article\t/second-article\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, tc.format, testRedirects); err != nil {
+				t.Fatal(err)
+			}
+			utesting.AssertEqual(t, tc.expected, buf.String())
+		})
+	}
+}
+
+func assertStub(t *testing.T, expected bool, filename string) {
+	t.Helper()
+
+	stub, err := IsStub(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stub != expected {
+		t.Errorf("expected '%s' to be a stub: %v, but got: %v", filename, expected, stub)
+	}
+}
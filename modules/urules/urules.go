@@ -0,0 +1,239 @@
+// Package urules is a declarative table of rules that determine how each
+// output of the build is served: its content type, its cache headers, and
+// whether it's worth precompressing. Everything that serves or uploads the
+// site consumes these rules so that local serving matches production.
+package urules
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Constants
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// DefaultContentType is the content type of outputs that no rule gives a
+// content type.
+const DefaultContentType = "application/octet-stream"
+
+const (
+	// LongCacheControl is for outputs that we expect to only have to
+	// invalidate very rarely, like images. Scripts and stylesheets are also
+	// given it because they're versioned by a path that changes with
+	// Release.
+	LongCacheControl = "public, max-age=86400"
+
+	// ShortCacheControl is for outputs that are expected to change more
+	// frequently, like any HTML page.
+	ShortCacheControl = "public, max-age=3600"
+)
+
+// Rules determine how outputs are served. For each of an output's content
+// type and cache control, the first rule that matches it and sets that
+// attribute wins. Compress is only considered on rules that set a content
+// type because whether an output is worth compressing depends on its type.
+var Rules = []*Rule{
+	//
+	// Cache control
+	//
+
+	// The search index lives with other assets, but it changes whenever an
+	// article does.
+	{Pattern: "/assets/search.json", CacheControl: ShortCacheControl},
+
+	{Pattern: "/assets/**", CacheControl: LongCacheControl},
+	{Pattern: "**", CacheControl: ShortCacheControl},
+
+	//
+	// Content types
+	//
+
+	{Pattern: "*.atom", ContentType: "application/xml", Compress: true},
+	{Pattern: "*.conf", ContentType: "text/plain", Compress: true},
+	{Pattern: "*.css", ContentType: "text/css", Compress: true},
+	{Pattern: "*.gif", ContentType: "image/gif"},
+	{Pattern: "*.html", ContentType: "text/html", Compress: true},
+	{Pattern: "*.ico", ContentType: "image/x-icon"},
+	{Pattern: "*.jpeg", ContentType: "image/jpeg"},
+	{Pattern: "*.jpg", ContentType: "image/jpeg"},
+	{Pattern: "*.js", ContentType: "text/javascript", Compress: true},
+	{Pattern: "*.json", ContentType: "application/json", Compress: true},
+	{Pattern: "*.png", ContentType: "image/png"},
+	{Pattern: "*.svg", ContentType: "image/svg+xml", Compress: true},
+	{Pattern: "*.tsv", ContentType: "text/tab-separated-values", Compress: true},
+	{Pattern: "*.txt", ContentType: "text/plain", Compress: true},
+	{Pattern: "*.xml", ContentType: "application/xml", Compress: true},
+
+	// Host configuration files like Netlify's `_headers` aren't served.
+	{Pattern: "_*", ContentType: "text/plain"},
+
+	// Any other asset is of a type we don't know about.
+	{Pattern: "/assets/**", ContentType: DefaultContentType},
+
+	// Pages are written without an extension so that their URLs don't need
+	// one, so anything else is HTML. This includes paths that look like they
+	// have an extension but don't, like an article slugged `v1.2-release`.
+	{Pattern: "**", ContentType: "text/html", Compress: true},
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Types
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Result is the outcome of applying Rules to an output.
+type Result struct {
+	// CacheControl is the value of the output's `Cache-Control` header. May
+	// be empty if no rule sets it.
+	CacheControl string
+
+	// Compress is whether the output is worth precompressing.
+	Compress bool
+
+	// ContentType is the output's content type.
+	ContentType string
+}
+
+// String produces a human-readable version of a result.
+func (r *Result) String() string {
+	return fmt.Sprintf("%s (cache-control: %q, compress: %v)", r.ContentType, r.CacheControl, r.Compress)
+}
+
+// Rule is a rule for how outputs matching a pattern are served.
+type Rule struct {
+	// CacheControl is the value of the `Cache-Control` header for matching
+	// outputs. Leave empty to not set it.
+	CacheControl string
+
+	// Compress is whether matching outputs are worth precompressing. Only
+	// considered if ContentType is set.
+	Compress bool
+
+	// ContentType is the content type of matching outputs. Leave empty to
+	// not set it.
+	ContentType string
+
+	// Pattern is a glob matched against an output's path, like
+	// `/assets/search.json`. `*` matches any characters except a slash and
+	// `**` matches any characters including slashes. Patterns without a
+	// slash are matched against an output's base name instead.
+	Pattern string
+
+	re     *regexp.Regexp
+	reOnce sync.Once
+}
+
+// Match checks whether the rule matches the output at path p.
+func (r *Rule) Match(p string) bool {
+	r.reOnce.Do(func() {
+		r.re = regexp.MustCompile(r.Regexp())
+	})
+	return r.re.MatchString(p)
+}
+
+// Regexp produces a regular expression equivalent to the rule's pattern. It
+// uses a syntax that's compatible with both Go and nginx.
+func (r *Rule) Regexp() string {
+	var sb strings.Builder
+
+	sb.WriteString("^")
+	if !strings.Contains(r.Pattern, "/") {
+		sb.WriteString("(.*/)?")
+	}
+
+	for i := 0; i < len(r.Pattern); i++ {
+		switch {
+		case strings.HasPrefix(r.Pattern[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case r.Pattern[i] == '*':
+			sb.WriteString("[^/]*")
+		default:
+			sb.WriteString(regexp.QuoteMeta(r.Pattern[i : i+1]))
+		}
+	}
+
+	sb.WriteString("$")
+	return sb.String()
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Functions
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Extensions gets the content types of rules that match a file extension,
+// like `*.css`, keyed by extension (`.css`). It's useful for configuring
+// hosts that assign content types by extension.
+func Extensions() map[string]string {
+	exts := make(map[string]string)
+	for _, rule := range Rules {
+		if rule.ContentType == "" || rule.ContentType == DefaultContentType {
+			continue
+		}
+
+		ext := path.Ext(rule.Pattern)
+		if rule.Pattern != "*"+ext || ext == "" {
+			continue
+		}
+
+		if _, ok := exts[ext]; !ok {
+			exts[ext] = rule.ContentType
+		}
+	}
+	return exts
+}
+
+// Match applies Rules to the output at path p, like `/index.html`.
+func Match(p string) *Result {
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+
+	result := &Result{ContentType: DefaultContentType}
+
+	var cacheControlSet, contentTypeSet bool
+	for _, rule := range Rules {
+		if (cacheControlSet || rule.CacheControl == "") &&
+			(contentTypeSet || rule.ContentType == "") {
+			continue
+		}
+
+		if !rule.Match(p) {
+			continue
+		}
+
+		if !cacheControlSet && rule.CacheControl != "" {
+			result.CacheControl = rule.CacheControl
+			cacheControlSet = true
+		}
+
+		if !contentTypeSet && rule.ContentType != "" {
+			result.Compress = rule.Compress
+			result.ContentType = rule.ContentType
+			contentTypeSet = true
+		}
+	}
+
+	return result
+}
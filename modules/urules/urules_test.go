@@ -0,0 +1,63 @@
+package urules
+
+import (
+	"testing"
+)
+
+func TestExtensions(t *testing.T) {
+	exts := Extensions()
+
+	if exts[".css"] != "text/css" {
+		t.Errorf("expected text/css for .css, got %q", exts[".css"])
+	}
+	if _, ok := exts[".*"]; ok {
+		t.Errorf("expected no catch-all extension")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for p, expected := range map[string]Result{
+		"/":                        {ShortCacheControl, true, "text/html"},
+		"/404.html":                {ShortCacheControl, true, "text/html"},
+		"/_headers":                {ShortCacheControl, false, "text/plain"},
+		"/articles.atom":           {ShortCacheControl, true, "application/xml"},
+		"/assets/123/app.css":      {LongCacheControl, true, "text/css"},
+		"/assets/images/a/b/c.png": {LongCacheControl, false, "image/png"},
+		"/assets/search.json":      {ShortCacheControl, true, "application/json"},
+		"/assets/unknown":          {LongCacheControl, false, DefaultContentType},
+		"/assets/unknown.unknown":  {LongCacheControl, false, DefaultContentType},
+		"/first-article":           {ShortCacheControl, true, "text/html"},
+		"/fragments/v1.2-release":  {ShortCacheControl, true, "text/html"},
+		"/v1.2-release":            {ShortCacheControl, true, "text/html"},
+		"/fragments/a-fragment":    {ShortCacheControl, true, "text/html"},
+		"/fragments/index.html":    {ShortCacheControl, true, "text/html"},
+		"robots.txt":               {ShortCacheControl, true, "text/plain"},
+	} {
+		if actual := Match(p); *actual != expected {
+			t.Errorf("for %q expected %v, got %v", p, &expected, actual)
+		}
+	}
+}
+
+func TestRuleMatch(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		p       string
+		match   bool
+	}{
+		{"*.css", "/app.css", true},
+		{"*.css", "/assets/123/app.css", true},
+		{"*.css", "/app.css.gz", false},
+		{"/assets/*", "/assets/app.css", true},
+		{"/assets/*", "/assets/images/a.png", false},
+		{"/assets/**", "/assets/images/a.png", true},
+		{"/assets/**", "/assets-other/a.png", false},
+		{"/assets/search.json", "/assets/searchxjson", false},
+	}
+	for _, tc := range testCases {
+		rule := &Rule{Pattern: tc.pattern}
+		if actual := rule.Match(tc.p); actual != tc.match {
+			t.Errorf("expected %q matching %q to be %v", tc.pattern, tc.p, tc.match)
+		}
+	}
+}
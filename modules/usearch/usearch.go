@@ -0,0 +1,322 @@
+// Package usearch builds a compact inverted index of the site's articles that
+// can be queried entirely in the browser, along with the tokenizing and
+// stemming that goes into it.
+//
+// The stemmer is deliberately simple because it has to be reimplemented
+// exactly in `content/javascripts/search.js` so that queries are stemmed the
+// same way that the index was. Any change to Tokenize or Stem must be
+// mirrored there.
+package usearch
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Constants
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+const (
+	// TitleBoost is the weight given to an occurrence of a term in a
+	// document's title relative to an occurrence in its body.
+	TitleBoost = 10
+
+	// minTokenLength is the minimum length of a token for it to be indexed.
+	minTokenLength = 2
+)
+
+// Common English words which aren't worth indexing.
+var stopWords = map[string]struct{}{
+	"a": {}, "about": {}, "an": {}, "and": {}, "are": {}, "as": {}, "at": {},
+	"be": {}, "but": {}, "by": {}, "can": {}, "for": {}, "from": {}, "had": {},
+	"has": {}, "have": {}, "he": {}, "i": {}, "if": {}, "in": {}, "into": {},
+	"is": {}, "it": {}, "its": {}, "just": {}, "me": {}, "my": {}, "not": {},
+	"of": {}, "on": {}, "or": {}, "so": {}, "some": {}, "such": {}, "than": {},
+	"that": {}, "the": {}, "their": {}, "them": {}, "then": {}, "there": {},
+	"these": {}, "they": {}, "this": {}, "to": {}, "was": {}, "we": {},
+	"were": {}, "what": {}, "when": {}, "which": {}, "will": {}, "with": {},
+	"would": {}, "you": {}, "your": {},
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Types
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Document is a single document to be added to an index.
+type Document struct {
+	// PublishedAt is when the document was published.
+	PublishedAt time.Time
+
+	// Slug identifies the document and is where it's addressable by URL.
+	Slug string
+
+	// Text is the plain text body of the document.
+	Text string
+
+	// Title is the document's title.
+	Title string
+}
+
+// Index is an inverted index over a set of documents. It's designed to
+// serialize to compact JSON.
+type Index struct {
+	// Docs contains summary information for every indexed document. Postings
+	// in Terms refer to documents by their position in this slice.
+	Docs []*IndexDoc `json:"docs"`
+
+	// Terms maps stemmed terms to postings of documents that contain them.
+	// Each posting is a pair of a document's index in Docs and the term's
+	// score in that document. Postings are sorted by document index.
+	Terms map[string][][2]int `json:"terms"`
+}
+
+// Search finds documents in the index matching the query, ordered from most
+// to least relevant. Each document is scored by summing the scores of its
+// matching terms, weighted by how rare each term is across all documents.
+//
+// This is the same scoring used by `content/javascripts/search.js`.
+func (idx *Index) Search(query string) []*Result {
+	scores := make(map[int]float64)
+
+	for _, term := range Tokenize(query) {
+		postings, ok := idx.Terms[term]
+		if !ok {
+			continue
+		}
+
+		idf := math.Log(1 + float64(len(idx.Docs))/float64(len(postings)))
+		for _, posting := range postings {
+			scores[posting[0]] += float64(posting[1]) * idf
+		}
+	}
+
+	results := make([]*Result, 0, len(scores))
+	for i, score := range scores {
+		results = append(results, &Result{Doc: idx.Docs[i], DocIndex: i, Score: score})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].DocIndex < results[j].DocIndex
+	})
+
+	return results
+}
+
+// IndexDoc is summary information on an indexed document used to display it
+// in search results.
+type IndexDoc struct {
+	// Date is the document's publish date formatted as `YYYY-MM-DD`.
+	Date string `json:"d"`
+
+	// Slug identifies the document and is where it's addressable by URL.
+	Slug string `json:"s"`
+
+	// Title is the document's title.
+	Title string `json:"t"`
+}
+
+// Result is a document matching a search query.
+type Result struct {
+	// Doc is the matching document.
+	Doc *IndexDoc
+
+	// DocIndex is the position of the matching document in the index's Docs
+	// and in the slice of documents that the index was built from.
+	DocIndex int
+
+	// Score is the relevance of the document to the query. Higher is more
+	// relevant.
+	Score float64
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Functions
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// NewIndex builds an index over the given documents.
+func NewIndex(docs []*Document) *Index {
+	index := &Index{
+		Terms: make(map[string][][2]int),
+	}
+
+	for i, doc := range docs {
+		index.Docs = append(index.Docs, &IndexDoc{
+			Date:  doc.PublishedAt.Format("2006-01-02"),
+			Slug:  doc.Slug,
+			Title: doc.Title,
+		})
+
+		scores := make(map[string]int)
+		for _, term := range Tokenize(doc.Title) {
+			scores[term] += TitleBoost
+		}
+		for _, term := range Tokenize(doc.Text) {
+			scores[term]++
+		}
+
+		for term, score := range scores {
+			index.Terms[term] = append(index.Terms[term], [2]int{i, score})
+		}
+	}
+
+	return index
+}
+
+// Snippet extracts a short run of numWords words from text around the first
+// word that matches one of the query's terms, or from its beginning if none
+// do. The run is kept numWords long where text allows even if the match is
+// near either end. Each matching word in the snippet is passed through
+// highlight, which may be nil.
+func Snippet(text, query string, numWords int, highlight func(string) string) string {
+	queryTerms := make(map[string]struct{})
+	for _, term := range Tokenize(query) {
+		queryTerms[term] = struct{}{}
+	}
+
+	matches := func(word string) bool {
+		for _, term := range Tokenize(word) {
+			if _, ok := queryTerms[term]; ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	words := strings.Fields(text)
+
+	first := 0
+	for i, word := range words {
+		if matches(word) {
+			first = i
+			break
+		}
+	}
+
+	start := first - numWords/2
+	if start+numWords > len(words) {
+		start = len(words) - numWords
+	}
+	if start < 0 {
+		start = 0
+	}
+	end := start + numWords
+	if end > len(words) {
+		end = len(words)
+	}
+
+	snippet := make([]string, 0, end-start)
+	for _, word := range words[start:end] {
+		if highlight != nil && matches(word) {
+			word = highlight(word)
+		}
+		snippet = append(snippet, word)
+	}
+
+	s := strings.Join(snippet, " ")
+	if start > 0 {
+		s = "…" + s
+	}
+	if end < len(words) {
+		s += "…"
+	}
+
+	return s
+}
+
+// Stem reduces a lowercased word to a stem by stripping common English
+// suffixes so that words like "deploy", "deploys", "deployed", and
+// "deploying" are all indexed as the same term.
+func Stem(word string) string {
+	if utf8.RuneCountInString(word) <= 3 {
+		return word
+	}
+
+	// Plurals.
+	switch {
+	case strings.HasSuffix(word, "sses"):
+		word = word[:len(word)-2]
+	case strings.HasSuffix(word, "ies"):
+		word = word[:len(word)-3] + "y"
+	case strings.HasSuffix(word, "s") &&
+		!strings.HasSuffix(word, "ss") &&
+		!strings.HasSuffix(word, "us") &&
+		!strings.HasSuffix(word, "is"):
+		word = word[:len(word)-1]
+	}
+
+	// Verb and adverb endings, but only when they leave behind a plausible
+	// stem.
+	for _, suffix := range []string{"ingly", "edly", "ing", "ed", "ly"} {
+		if !strings.HasSuffix(word, suffix) {
+			continue
+		}
+
+		stem := word[:len(word)-len(suffix)]
+		if utf8.RuneCountInString(stem) < 3 || !strings.ContainsAny(stem, "aeiouy") {
+			break
+		}
+
+		// Undouble a trailing consonant, like in "running".
+		n := len(stem)
+		if last := stem[n-1]; last >= 'a' && last <= 'z' && last == stem[n-2] &&
+			!strings.ContainsRune("aeioulsz", rune(last)) {
+			stem = stem[:n-1]
+		}
+
+		word = stem
+		break
+	}
+
+	return word
+}
+
+// Tokenize splits text into lowercased, stemmed terms, dropping any that are
+// too short or too common to be worth indexing. Words are separated by
+// anything that isn't a letter or number, which is `[^\p{L}\p{N}]` in
+// JavaScript.
+func Tokenize(s string) []string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	var terms []string
+	for _, word := range words {
+		if utf8.RuneCountInString(word) < minTokenLength {
+			continue
+		}
+
+		if _, ok := stopWords[word]; ok {
+			continue
+		}
+
+		terms = append(terms, Stem(word))
+	}
+
+	return terms
+}
@@ -0,0 +1,296 @@
+package usearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/brandur/mutelight/internal/utesting"
+)
+
+// termsFixture pins the terms that text is tokenized into. The same cases are
+// run through `content/javascripts/search.js` so that queries typed into the
+// browser are guaranteed to find the terms that were indexed.
+const termsFixture = "./testdata/terms.json"
+
+// searchJS is the client-side implementation of tokenizing and searching.
+const searchJS = "../../content/javascripts/search.js"
+
+func TestIndexSearch(t *testing.T) {
+	index := NewIndex(searchDocs)
+
+	testCases := []struct {
+		query    string
+		expected []string
+	}{
+		// "deploy" only appears in one document so it's weighed more heavily
+		// than "boring", and a match in a title outweighs several in bodies.
+		{"boring deploys", []string{"deploying-go", "boring-technology", "postgres"}},
+
+		// Documents that score the same are ordered as they were indexed.
+		{"go", []string{"deploying-go", "go-generics"}},
+
+		{"kubernetes", []string{}},
+		{"the and of", []string{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			results := index.Search(tc.query)
+
+			slugs := []string{}
+			for i, result := range results {
+				slugs = append(slugs, result.Doc.Slug)
+
+				if i > 0 && result.Score > results[i-1].Score {
+					t.Errorf("expected results in descending order of score, got %v after %v",
+						result.Score, results[i-1].Score)
+				}
+			}
+			utesting.AssertEqual(t, tc.expected, slugs)
+		})
+	}
+}
+
+func TestNewIndex(t *testing.T) {
+	index := NewIndex([]*Document{
+		{
+			PublishedAt: time.Date(2011, 3, 14, 0, 0, 0, 0, time.UTC),
+			Slug:        "deploying",
+			Text:        "Deploys should be boring.",
+			Title:       "Deploying",
+		},
+		{
+			PublishedAt: time.Date(2012, 1, 5, 0, 0, 0, 0, time.UTC),
+			Slug:        "boring",
+			Text:        "Boring technology.",
+			Title:       "Boring",
+		},
+	})
+
+	utesting.AssertEqual(t, []*IndexDoc{
+		{Date: "2011-03-14", Slug: "deploying", Title: "Deploying"},
+		{Date: "2012-01-05", Slug: "boring", Title: "Boring"},
+	}, index.Docs)
+
+	utesting.AssertEqual(t, map[string][][2]int{
+		"bor":        {{0, 1}, {1, TitleBoost + 1}},
+		"deploy":     {{0, TitleBoost + 1}},
+		"should":     {{0, 1}},
+		"technology": {{1, 1}},
+	}, index.Terms)
+}
+
+// TestSearchJavaScript checks that search.js ranks results under Node in the
+// same order as Index.Search. It's skipped if Node isn't installed.
+func TestSearchJavaScript(t *testing.T) {
+	index := NewIndex(searchDocs)
+	queries := []string{"boring deploys", "go", "kubernetes"}
+
+	var actual [][]string
+	runSearchJS(t, `input.queries.map(function(query) {
+  return search.search(input.index, query).map(function(result) {
+    return result.doc.s;
+  });
+})`, map[string]interface{}{"index": index, "queries": queries}, &actual)
+
+	for i, query := range queries {
+		t.Run(query, func(t *testing.T) {
+			expected := []string{}
+			for _, result := range index.Search(query) {
+				expected = append(expected, result.Doc.Slug)
+			}
+			utesting.AssertEqual(t, expected, actual[i])
+		})
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	text := "one two three four five six seven eight nine ten"
+
+	highlight := func(word string) string {
+		return "[" + word + "]"
+	}
+
+	testCases := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{"NoMatch", "kubernetes", "one two three four…"},
+		{"MatchAtStart", "two", "one [two] three four…"},
+		{"MatchInMiddle", "six", "…four five [six] seven…"},
+		{"MatchAtEnd", "ten", "…seven eight nine [ten]"},
+
+		// The run is around whichever term matches first, and every match
+		// in it is highlighted.
+		{"MultipleTerms", "eight five", "…three four [five] six…"},
+		{"MultipleTermsBothInRun", "six five", "…three four [five] [six]…"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			utesting.AssertEqual(t, tc.expected, Snippet(text, tc.query, 4, highlight))
+		})
+	}
+
+	// Words match query terms after stemming and without punctuation.
+	utesting.AssertEqual(t, "[Deploying] Go is [boring.]",
+		Snippet("Deploying Go is boring.", "deploys bored", 10, highlight))
+
+	// Text shorter than the run is returned whole.
+	utesting.AssertEqual(t, "short text", Snippet("short text", "text", 10, nil))
+}
+
+func TestStem(t *testing.T) {
+	testCases := []struct {
+		word     string
+		expected string
+	}{
+		// Short words are left alone.
+		{"bus", "bus"},
+		{"is", "is"},
+
+		// Plurals.
+		{"analysis", "analysis"},
+		{"classes", "class"},
+		{"deploys", "deploy"},
+		{"glass", "glass"},
+		{"libraries", "library"},
+		{"status", "status"},
+
+		// Verb and adverb endings.
+		{"deployed", "deploy"},
+		{"deploying", "deploy"},
+		{"quickly", "quick"},
+		{"repeatedly", "repeat"},
+		{"surprisingly", "surpris"},
+
+		// Trailing consonants are undoubled, except for ones that are
+		// commonly doubled in the stem itself.
+		{"falling", "fall"},
+		{"fizzing", "fizz"},
+		{"hopping", "hop"},
+		{"missed", "miss"},
+		{"running", "run"},
+
+		// Endings aren't stripped if they'd leave too short a stem or one
+		// without a vowel.
+		{"bring", "bring"},
+		{"sled", "sled"},
+		{"thing", "thing"},
+
+		// Non-ASCII words.
+		{"cafés", "café"},
+		{"naïvely", "naïve"},
+		{"日本語", "日本語"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.word, func(t *testing.T) {
+			utesting.AssertEqual(t, tc.expected, Stem(tc.word))
+		})
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	for _, tc := range readTermsFixture(t) {
+		t.Run(tc.Text, func(t *testing.T) {
+			utesting.AssertEqual(t, tc.Terms, nonNil(Tokenize(tc.Text)))
+		})
+	}
+}
+
+// TestTokenizeJavaScript runs the cases in termsFixture through search.js
+// under Node. It's skipped if Node isn't installed.
+func TestTokenizeJavaScript(t *testing.T) {
+	cases := readTermsFixture(t)
+
+	var actual [][]string
+	runSearchJS(t, `input.map(function(c) { return search.tokenize(c.text); })`, cases, &actual)
+
+	for i, tc := range cases {
+		t.Run(tc.Text, func(t *testing.T) {
+			utesting.AssertEqual(t, tc.Terms, actual[i])
+		})
+	}
+}
+
+// nonNil makes terms comparable to an empty list decoded from JSON.
+func nonNil(terms []string) []string {
+	if terms == nil {
+		return []string{}
+	}
+	return terms
+}
+
+// runSearchJS loads search.js under Node as `search`, evaluates expr with
+// `input` set to input, and decodes the result into out. The test is skipped
+// if Node isn't installed.
+func runSearchJS(t *testing.T, expr string, input, out interface{}) {
+	t.Helper()
+
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node not installed")
+	}
+
+	script, err := filepath.Abs(searchJS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(node, "-e", `
+var search = require(process.argv[1]);
+var input = JSON.parse(require("fs").readFileSync(0, "utf8"));
+process.stdout.write(JSON.stringify(`+expr+`));
+`, script)
+	cmd.Stdin = bytes.NewReader(data)
+
+	result, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("error running %s: %v", searchJS, err)
+	}
+
+	if err := json.Unmarshal(result, out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Documents for testing searches. Their order matters because it breaks ties
+// between results.
+var searchDocs = []*Document{
+	{Slug: "boring-technology", Title: "Boring Technology", Text: "Choose boring technology."},
+	{Slug: "deploying-go", Title: "Deploying Go", Text: "Deploying Go programs is boring."},
+	{Slug: "go-generics", Title: "Go Generics", Text: "Generics in Go."},
+	{Slug: "postgres", Title: "Postgres", Text: "Postgres is boring technology too."},
+}
+
+type termsCase struct {
+	Text  string   `json:"text"`
+	Terms []string `json:"terms"`
+}
+
+func readTermsFixture(t *testing.T) []*termsCase {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(termsFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cases []*termsCase
+	if err := json.Unmarshal(data, &cases); err != nil {
+		t.Fatal(err)
+	}
+	return cases
+}
@@ -0,0 +1,190 @@
+// Package uviews produces a dependency graph of Ace templates by parsing the
+// `= include` directives in each one, so that a change to a partial view only
+// rebuilds the pages that actually include it.
+package uviews
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/mutelight/modules/ucommon"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Constants
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Ext is the extension of Ace templates. Ace adds it to the names given to
+// `= include`.
+const Ext = ".ace"
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Types
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Graph maps the path of each template to the paths of the templates that it
+// includes directly. Paths are normalized with ucommon.CleanPath, so
+// `./views/index.ace` appears as `views/index.ace`.
+type Graph map[string][]string
+
+// Dependencies gets templates along with every template that they include,
+// directly or transitively. Templates are returned first, followed by their
+// includes in sorted order, and all of them are normalized with ucommon.CleanPath.
+func (g Graph) Dependencies(templates ...string) []string {
+	seen := make(map[string]bool)
+	var deps, includes []string
+
+	var visit func(template string)
+	visit = func(template string) {
+		for _, include := range g[template] {
+			if seen[include] {
+				continue
+			}
+			seen[include] = true
+			includes = append(includes, include)
+			visit(include)
+		}
+	}
+
+	for _, template := range templates {
+		template = ucommon.CleanPath(template)
+		seen[template] = true
+		deps = append(deps, template)
+	}
+	for _, template := range deps {
+		visit(template)
+	}
+
+	sort.Strings(includes)
+	return append(deps, includes...)
+}
+
+// Write prints the graph with each template followed by the templates it
+// includes directly, indented. Templates are listed in sorted order.
+func (g Graph) Write(w io.Writer) error {
+	templates := make([]string, 0, len(g))
+	for template := range g {
+		templates = append(templates, template)
+	}
+	sort.Strings(templates)
+
+	for _, template := range templates {
+		if _, err := fmt.Fprintf(w, "%s\n", template); err != nil {
+			return xerrors.Errorf("error writing graph: %w", err)
+		}
+		for _, include := range g[template] {
+			if _, err := fmt.Fprintf(w, "    %s\n", include); err != nil {
+				return xerrors.Errorf("error writing graph: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Functions
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// Build parses every template under dirs to produce a graph. Included names
+// are resolved in the same way as Ace does, relative to the working directory
+// rather than the including template.
+func Build(dirs ...string) (Graph, error) {
+	graph := make(Graph)
+
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() || filepath.Ext(p) != Ext {
+				return nil
+			}
+
+			includes, err := parseIncludes(p)
+			if err != nil {
+				return err
+			}
+
+			graph[ucommon.CleanPath(p)] = includes
+			return nil
+		})
+		if err != nil {
+			return nil, xerrors.Errorf("error walking directory '%s': %w", dir, err)
+		}
+	}
+
+	return graph, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Private
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// parseIncludes gets the paths of templates included by the template at p in
+// sorted order. Includes are found in the same way as Ace finds them: any
+// line whose first space-separated tokens after its indentation are `=` and
+// `include`, like `= include views/_analytics .`. Ace doesn't recognize them
+// anywhere else, like after an element on the same line.
+func parseIncludes(p string) ([]string, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, xerrors.Errorf("error reading file '%s': %w", p, err)
+	}
+
+	seen := make(map[string]bool)
+	includes := []string{}
+
+	for _, line := range strings.Split(strings.ReplaceAll(string(data), "\r", "\n"), "\n") {
+		tokens := strings.Split(strings.TrimLeft(line, " "), " ")
+		if len(tokens) < 3 || tokens[0] != "=" || tokens[1] != "include" {
+			continue
+		}
+
+		include := ucommon.CleanPath(tokens[2])
+		if !strings.HasSuffix(include, Ext) {
+			include += Ext
+		}
+
+		if seen[include] {
+			continue
+		}
+		seen[include] = true
+		includes = append(includes, include)
+	}
+
+	sort.Strings(includes)
+	return includes, nil
+}
@@ -0,0 +1,140 @@
+package uviews
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/brandur/mutelight/internal/utesting"
+)
+
+func TestBuild(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+
+	utesting.WriteFile(t, "layouts/main.ace", "html\n  body\n    = yield main\n    = include views/_analytics .\n")
+	utesting.WriteFile(t, "views/_analytics.ace", "script\n")
+	utesting.WriteFile(t, "views/_nav.ace", "nav\n  = include views/_search .\n")
+	utesting.WriteFile(t, "views/_search.ace", "form\n")
+	utesting.WriteFile(t, "views/articles/show.ace", "= content main\n  = include views/_nav .\n  = include views/_nav .\n")
+	utesting.WriteFile(t, "views/index.ace", "= content main\n  p Hello\n")
+
+	graph, err := Build("./layouts", "./views")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Graph{
+		"layouts/main.ace":        {"views/_analytics.ace"},
+		"views/_analytics.ace":    {},
+		"views/_nav.ace":          {"views/_search.ace"},
+		"views/_search.ace":       {},
+		"views/articles/show.ace": {"views/_nav.ace"},
+		"views/index.ace":         {},
+	}
+	if !reflect.DeepEqual(expected, graph) {
+		t.Errorf("expected %v, got %v", expected, graph)
+	}
+}
+
+// TestBuildIncludeSyntax checks that includes are found only where Ace
+// finds them.
+func TestBuildIncludeSyntax(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+
+	utesting.WriteFile(t, "views/index.ace", strings.Join([]string{
+		"= content main",
+		"  = include views/_indented .",
+		"  div",
+		"    = include views/_nested",
+		"= include ./views/_unclean.ace .\r",
+
+		// Ace doesn't recognize any of these as includes.
+		"  p = include views/_after_element .",
+		"  =include views/_no_space .",
+		"  =  include views/_two_spaces .",
+		"  | = include views/_in_text .",
+		"\t= include views/_tab_indented .",
+		"  {{template \"views/_template_action\" .}}",
+	}, "\n"))
+
+	graph, err := Build("./views")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	utesting.AssertEqual(t, []string{
+		"views/_indented.ace",
+		"views/_nested.ace",
+		"views/_unclean.ace",
+	}, graph["views/index.ace"])
+}
+
+func TestGraphDependencies(t *testing.T) {
+	graph := Graph{
+		"layouts/main.ace":        {"views/_analytics.ace"},
+		"views/_analytics.ace":    {},
+		"views/_cycle.ace":        {"views/_nav.ace"},
+		"views/_nav.ace":          {"views/_cycle.ace", "views/_search.ace"},
+		"views/_search.ace":       {},
+		"views/articles/show.ace": {"views/_nav.ace"},
+		"views/index.ace":         {},
+	}
+
+	// Templates are returned first and cleaned like their includes.
+	utesting.AssertEqual(t, []string{
+		"layouts/main.ace",
+		"views/articles/show.ace",
+		"views/_analytics.ace",
+		"views/_cycle.ace",
+		"views/_nav.ace",
+		"views/_search.ace",
+	}, graph.Dependencies("./layouts/main.ace", "./views/articles/show.ace"))
+
+	// A page that doesn't include a partial doesn't depend on it.
+	utesting.AssertEqual(t, []string{
+		"layouts/main.ace",
+		"views/index.ace",
+		"views/_analytics.ace",
+	}, graph.Dependencies("./layouts/main.ace", "./views/index.ace"))
+}
+
+func TestGraphWrite(t *testing.T) {
+	graph := Graph{
+		"views/_nav.ace":  {"views/_search.ace"},
+		"views/index.ace": {"views/_analytics.ace", "views/_nav.ace"},
+	}
+
+	var buf bytes.Buffer
+	if err := graph.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	utesting.AssertEqual(t, "views/_nav.ace\n"+
+		"    views/_search.ace\n"+
+		"views/index.ace\n"+
+		"    views/_analytics.ace\n"+
+		"    views/_nav.ace\n", buf.String())
+}
+
+// chdir changes the working directory for the duration of the test because
+// included templates are resolved relative to it.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := os.Chdir(wd); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
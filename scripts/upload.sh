@@ -0,0 +1,31 @@
+#!/bin/bash
+
+# Uploads objects listed by `mutelight uploads` to S3 with the headers listed
+# alongside them. Lines are read from stdin.
+#
+# Usage:
+#     mutelight uploads <target dir> | scripts/upload.sh <target dir> <bucket> [aws flags]
+
+set -e
+
+target_dir="$1"
+bucket="$2"
+shift 2
+
+while IFS=$'\t' read -r key file content_type cache_control content_encoding redirect; do
+    args=(--acl public-read --content-type "${content_type}")
+
+    if [[ "${cache_control}" != "-" ]]; then
+        args+=(--cache-control "${cache_control}")
+    fi
+
+    if [[ "${content_encoding}" != "-" ]]; then
+        args+=(--content-encoding "${content_encoding}")
+    fi
+
+    if [[ "${redirect}" != "-" ]]; then
+        args+=(--website-redirect "${redirect}")
+    fi
+
+    aws s3 cp "${target_dir}/${file}" "s3://${bucket}/${key}" "${args[@]}" "$@" < /dev/null
+done
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/mutelight/modules/usearch"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Types
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// searchOptions are options for the `search` command.
+type searchOptions struct {
+	// JSON produces results as JSON instead of as human-readable text.
+	JSON bool
+
+	// Limit is the maximum number of results to print.
+	Limit int
+}
+
+// searchResult is a single search result as it's printed with `--json`.
+type searchResult struct {
+	PublishedAt time.Time `json:"published_at"`
+	Score       float64   `json:"score"`
+	Slug        string    `json:"slug"`
+	Snippet     string    `json:"snippet"`
+	Title       string    `json:"title"`
+	URL         string    `json:"url"`
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Private
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+const (
+	// searchSnippetWords is the number of words included in each result's
+	// snippet.
+	searchSnippetWords = 30
+)
+
+// searchArticles parses every article, indexes them in memory, and prints
+// the ones that match query.
+func searchArticles(query string, opts *searchOptions) error {
+	c := getModulirContext()
+
+	sources, err := filepath.Glob(filepath.Join(c.SourceDir, "content", "articles", "*.md"))
+	if err != nil {
+		return xerrors.Errorf("error listing articles: %w", err)
+	}
+
+	var articles []*Article
+	for _, source := range sources {
+		article, err := parseArticle(c, source)
+		if err != nil {
+			return err
+		}
+		articles = append(articles, article)
+	}
+	sortArticles(articles)
+
+	docs := make([]*usearch.Document, len(articles))
+	for i, article := range articles {
+		docs[i] = &usearch.Document{
+			PublishedAt: *article.PublishedAt,
+			Slug:        article.Slug,
+			Text:        plainText(article.Content),
+			Title:       article.Title,
+		}
+	}
+
+	results := usearch.NewIndex(docs).Search(query)
+	if opts.Limit > 0 && len(results) > opts.Limit {
+		results = results[:opts.Limit]
+	}
+
+	if opts.JSON {
+		out := make([]*searchResult, len(results))
+		for i, result := range results {
+			article := articles[result.DocIndex]
+			out[i] = &searchResult{
+				PublishedAt: *article.PublishedAt,
+				Score:       result.Score,
+				Slug:        article.Slug,
+				Snippet:     usearch.Snippet(docs[result.DocIndex].Text, query, searchSnippetWords, nil),
+				Title:       article.Title,
+				URL:         absoluteURL(article.Slug),
+			}
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(out); err != nil {
+			return xerrors.Errorf("error encoding results: %w", err)
+		}
+		return nil
+	}
+
+	if len(results) < 1 {
+		fmt.Fprintf(os.Stderr, "No articles matched: %s\n", query)
+		return nil
+	}
+
+	// Only highlight with terminal escape codes if there's a terminal to
+	// interpret them.
+	var highlight func(string) string
+	if terminal.IsTerminal(int(os.Stdout.Fd())) {
+		highlight = func(s string) string {
+			return "\x1b[1m" + s + "\x1b[0m"
+		}
+	}
+
+	for _, result := range results {
+		article := articles[result.DocIndex]
+		fmt.Printf("%s  %s  (%s)\n", article.Slug, article.Title,
+			article.PublishedAt.Format("2006-01-02"))
+		fmt.Printf("    %s\n\n",
+			usearch.Snippet(docs[result.DocIndex].Text, query, searchSnippetWords, highlight))
+	}
+
+	return nil
+}
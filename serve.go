@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Private
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// newServeHandler produces a handler for the `serve` command that serves the
+// site already built in targetDir the way it's served in production. Every
+// request is resolved against the objects that `make deploy` would upload
+// (see listUploads) so that headers, precompressed outputs, directory index
+// aliases, and redirects all behave the same as they do on S3 and
+// CloudFront. Anything else gets the site's 404 page.
+//
+// Objects are listed once up front, so the handler doesn't pick up outputs
+// added to targetDir after it's created.
+func newServeHandler(targetDir string) (http.Handler, error) {
+	uploads, err := listUploads(targetDir, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make(map[string]*upload, len(uploads))
+	for _, u := range uploads {
+		objects[u.Key] = u
+	}
+
+	serveObject := func(w http.ResponseWriter, r *http.Request, u *upload, status int) {
+		filename := filepath.Join(targetDir, filepath.FromSlash(u.File))
+
+		f, err := os.Open(filename)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error opening file '%s': %v", filename, err),
+				http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error stating file '%s': %v", filename, err),
+				http.StatusInternalServerError)
+			return
+		}
+
+		if u.CacheControl != "" {
+			w.Header().Set("Cache-Control", u.CacheControl)
+		}
+		if u.ContentEncoding != "" {
+			w.Header().Set("Content-Encoding", u.ContentEncoding)
+		}
+		w.Header().Set("Content-Type", u.ContentType)
+
+		// ServeContent handles conditional and range requests, but always
+		// responds with a success, so error pages are written directly.
+		if status != http.StatusOK {
+			w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+			w.WriteHeader(status)
+			if r.Method != http.MethodHead {
+				_, _ = io.Copy(w, f)
+			}
+			return
+		}
+
+		http.ServeContent(w, r, u.Key, info.ModTime(), f)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// CloudFront serves the site's index as its root object. Every other
+		// path maps directly onto an object's key.
+		key := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if key == "" {
+			key = "index.html"
+		}
+
+		u, ok := objects[key]
+		if !ok {
+			notFound, ok := objects["404.html"]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			serveObject(w, r, notFound, http.StatusNotFound)
+			return
+		}
+
+		if u.Redirect != "" {
+			http.Redirect(w, r, u.Redirect, http.StatusMovedPermanently)
+			return
+		}
+
+		serveObject(w, r, u, http.StatusOK)
+	}), nil
+}
+
+// serveSite serves the site already built in targetDir on port the way it's
+// served in production. See newServeHandler.
+func serveSite(port int, targetDir string) error {
+	handler, err := newServeHandler(targetDir)
+	if err != nil {
+		return err
+	}
+
+	getLog().Infof("Serving '%s' on http://localhost:%d", targetDir, port)
+
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), handler); err != nil {
+		return xerrors.Errorf("error serving on port %d: %w", port, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/modulir"
+	"github.com/brandur/mutelight/modules/ucommon"
+	"github.com/brandur/mutelight/modules/ucompress"
+	"github.com/brandur/mutelight/modules/uoverlay"
+	"github.com/brandur/mutelight/modules/urules"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Private
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// acceptsEncoding checks whether an `Accept-Encoding` header value accepts the
+// given encoding.
+func acceptsEncoding(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+
+		name, params := part, ""
+		if i := strings.Index(part, ";"); i != -1 {
+			name, params = strings.TrimSpace(part[:i]), part[i+1:]
+		}
+
+		if name != encoding {
+			continue
+		}
+
+		// An encoding can be explicitly refused with a quality of zero.
+		params = strings.ReplaceAll(params, " ", "")
+		return params != "q=0" && params != "q=0.0"
+	}
+	return false
+}
+
+// newBuildErrors converts errors returned by a build into errors shown by
+// the overlay. Errors from jobs are attributed to the job that failed.
+func newBuildErrors(errors []error) []*uoverlay.BuildError {
+	buildErrors := make([]*uoverlay.BuildError, 0, len(errors))
+	for _, err := range errors {
+		if err == nil {
+			continue
+		}
+
+		// Modulir returns the jobs that failed themselves as errors.
+		var jobName string
+		if job, ok := err.(*modulir.Job); ok {
+			jobName = job.Name
+			err = job.Err
+		}
+
+		buildErrors = append(buildErrors, uoverlay.NewBuildError(jobName, err, fileExists))
+	}
+	return buildErrors
+}
+
+// newLoopHandler produces a handler for the `loop` command's server. It
+// proxies everything (including the websocket used for live reload) to
+// Modulir's own server running at upstream, but serves the site's 404 page in
+// place of the bare error that Modulir's server responds with for missing
+// paths, and serves precompressed outputs where they exist. Outputs are
+// given the same headers by urules as they would be in production.
+//
+// If hub isn't nil, it's served at uoverlay.Path and pages have the overlay
+// script injected so that build errors show up in the browser.
+func newLoopHandler(upstream *url.URL, targetDir string, hub *uoverlay.Hub) http.Handler {
+	proxy := httputil.NewSingleHostReverseProxy(upstream)
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		switch resp.StatusCode {
+		case http.StatusOK:
+			setRuleHeaders(resp.Header, resp.Request.URL.Path)
+
+		case http.StatusNotFound:
+			data, err := ioutil.ReadFile(path.Join(targetDir, "404.html"))
+			if err != nil {
+				// The 404 page may not have been built yet, in which case
+				// pass through the original response.
+				return nil //nolint:nilerr
+			}
+
+			replaceBody(resp, data)
+			resp.Header.Set("Content-Type", urules.Match("/404.html").ContentType)
+
+		default:
+			return nil
+		}
+
+		if hub == nil {
+			return nil
+		}
+
+		// Pages and stylesheets are reloaded as they change in development,
+		// so never let the browser use stale copies from its cache.
+		resp.Header.Set("Cache-Control", "no-cache")
+
+		if resp.Header.Get("Content-Type") != "text/html" {
+			return nil
+		}
+
+		data, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return xerrors.Errorf("error reading response: %w", err)
+		}
+		resp.Body.Close()
+
+		replaceBody(resp, uoverlay.Inject(data))
+		return nil
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hub != nil && r.URL.Path == uoverlay.Path {
+			hub.ServeHTTP(w, r)
+			return
+		}
+		if servePrecompressed(w, r, targetDir, hub != nil) {
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+// reloadKind determines how pages open in the browser should be reloaded
+// after a build. If stylesheets were the only thing to change, and so no job
+// rendered anything that shows up in a page, stylesheets are swapped into
+// pages in place.
+func reloadKind(c *modulir.Context) string {
+	if c.FirstRun || c.Forced || !c.ChangedAny(stylesheetSources...) {
+		return uoverlay.ReloadPage
+	}
+
+	for _, job := range c.Stats.JobsExecuted {
+		var nonPage bool
+		for _, prefix := range nonPageJobPrefixes {
+			if strings.HasPrefix(job.Name, prefix) {
+				nonPage = true
+				break
+			}
+		}
+
+		if !nonPage {
+			return uoverlay.ReloadPage
+		}
+	}
+
+	return uoverlay.ReloadStylesheets
+}
+
+// replaceBody replaces the body of resp with data.
+func replaceBody(resp *http.Response, data []byte) {
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	resp.ContentLength = int64(len(data))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(data)))
+}
+
+// servePrecompressed serves the Brotli or gzip sibling of the requested
+// output if the client accepts it and it's up to date. Returns false if
+// nothing was served, in which case the request should be handled normally.
+//
+// HTML is never served precompressed here because Modulir's server injects
+// its live reload script into pages as it serves them. If noCache is set,
+// the browser is told not to cache the output.
+func servePrecompressed(w http.ResponseWriter, r *http.Request, targetDir string, noCache bool) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	result := urules.Match(r.URL.Path)
+	if !result.Compress || result.ContentType == "text/html" {
+		return false
+	}
+
+	filename := path.Join(targetDir, path.Clean("/"+r.URL.Path))
+	info, err := os.Stat(filename)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+
+	acceptEncoding := r.Header.Get("Accept-Encoding")
+	for _, encoding := range []struct {
+		name string
+		ext  string
+	}{
+		{"br", ucompress.BrotliExt},
+		{"gzip", ucompress.GzipExt},
+	} {
+		if !acceptsEncoding(acceptEncoding, encoding.name) {
+			continue
+		}
+
+		compressed := filename + encoding.ext
+		if !ucompress.UpToDate(compressed, info) {
+			continue
+		}
+
+		f, err := os.Open(compressed)
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+
+		setRuleHeaders(w.Header(), r.URL.Path)
+		if noCache {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		w.Header().Set("Content-Encoding", encoding.name)
+		w.Header().Add("Vary", "Accept-Encoding")
+		http.ServeContent(w, r, filename, info.ModTime(), f)
+		return true
+	}
+
+	return false
+}
+
+// setRuleHeaders sets the headers given by urules to the output at path p.
+func setRuleHeaders(header http.Header, p string) {
+	result := urules.Match(p)
+	if result.CacheControl != "" {
+		header.Set("Cache-Control", result.CacheControl)
+	}
+	header.Set("Content-Type", result.ContentType)
+}
+
+// startLoopServer starts the `loop` command's server on port in the
+// background. See newLoopHandler.
+func startLoopServer(port, upstreamPort int, targetDir string, hub *uoverlay.Hub) {
+	upstream := &url.URL{Scheme: "http", Host: fmt.Sprintf("localhost:%d", upstreamPort)}
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: newLoopHandler(upstream, targetDir, hub),
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil {
+			ucommon.ExitWithError(xerrors.Errorf("error serving on port %d: %w", port, err))
+		}
+	}()
+}
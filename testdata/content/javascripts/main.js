@@ -0,0 +1 @@
+// fixture
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/brandur/mutelight/modules/ucompress"
+	"github.com/brandur/mutelight/modules/uhosting"
+	"github.com/brandur/mutelight/modules/umanifest"
+	"github.com/brandur/mutelight/modules/uredirect"
+	"github.com/brandur/mutelight/modules/urules"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Types
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// uploadsOptions are options for listing uploads.
+type uploadsOptions struct {
+	// Since is the path to the manifest of the last deploy. Only outputs
+	// that were added or changed since are listed.
+	Since string
+}
+
+// upload is a single object uploaded to S3 by `make deploy`.
+type upload struct {
+	// CacheControl is the object's `Cache-Control` header, or empty.
+	CacheControl string
+
+	// ContentEncoding is the object's `Content-Encoding` header, or empty.
+	ContentEncoding string
+
+	// ContentType is the object's `Content-Type` header.
+	ContentType string
+
+	// File is the path of the file to upload relative to the target
+	// directory, like `index.html.gz`.
+	File string
+
+	// Key is the object's key in the bucket, like `index.html`.
+	Key string
+
+	// Redirect is a path that S3 should redirect to instead of serving the
+	// object, or empty.
+	Redirect string
+}
+
+//////////////////////////////////////////////////////////////////////////////
+//
+//
+//
+// Private
+//
+//
+//
+//////////////////////////////////////////////////////////////////////////////
+
+// listUploads lists the objects that `make deploy` uploads for the site in
+// targetDir along with the headers given to each by urules. If deployed is
+// the manifest of the last deploy, outputs whose contents are the same as
+// they were then are skipped.
+//
+// Directory indexes are also uploaded at their directory's name, so that for
+// example `articles/index.html` is also uploaded as `articles`. CloudFront
+// only has the notion of a root object rather than an index in every
+// directory, but files and directories can't share a name locally.
+func listUploads(targetDir string, deployed *umanifest.Manifest) ([]*upload, error) {
+	manifest, err := umanifest.Build(targetDir, func(string) []string { return nil })
+	if err != nil {
+		return nil, err
+	}
+
+	deployedHashes := make(map[string]string)
+	if deployed != nil {
+		for _, output := range deployed.Outputs {
+			deployedHashes[output.Path] = output.SHA256
+		}
+	}
+
+	redirects, err := readS3Redirects(targetDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var uploads []*upload
+	for _, output := range manifest.Outputs {
+		if uhosting.IsConfigFile(output.Path) {
+			continue
+		}
+
+		if deployedHashes[output.Path] == output.SHA256 {
+			continue
+		}
+
+		result := urules.Match(output.Path)
+		u := &upload{
+			CacheControl: result.CacheControl,
+			ContentType:  result.ContentType,
+			File:         strings.TrimPrefix(output.Path, "/"),
+			Key:          strings.TrimPrefix(output.Path, "/"),
+			Redirect:     redirects[output.Path],
+		}
+
+		// S3 can't negotiate encodings, so the gzip sibling (which every
+		// client supports) is uploaded in place of the original if there is
+		// one. Brotli siblings are left for hosts that can.
+		if result.Compress {
+			filename := filepath.Join(targetDir, filepath.FromSlash(u.File))
+			info, err := os.Stat(filename)
+			if err != nil {
+				return nil, xerrors.Errorf("error stating file '%s': %w", filename, err)
+			}
+
+			if ucompress.UpToDate(filename+ucompress.GzipExt, info) {
+				u.ContentEncoding = "gzip"
+				u.File += ucompress.GzipExt
+			}
+		}
+
+		uploads = append(uploads, u)
+
+		if path.Base(u.Key) == "index.html" && u.Key != "index.html" {
+			alias := *u
+			alias.Key = path.Dir(u.Key)
+			uploads = append(uploads, &alias)
+		}
+	}
+
+	return uploads, nil
+}
+
+// printUploads prints uploads with one per line as tab-separated key, file,
+// content type, cache control, content encoding, and redirect. Empty values
+// are printed as `-` so that every line has the same number of fields.
+func printUploads(w io.Writer, uploads []*upload) {
+	orDash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+
+	for _, u := range uploads {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			u.Key, u.File, orDash(u.ContentType), orDash(u.CacheControl),
+			orDash(u.ContentEncoding), orDash(u.Redirect))
+	}
+}
+
+// readS3Redirects reads redirects written to targetDir by the S3 redirect
+// format keyed by the path they redirect from. Returns an empty map if there
+// are none.
+func readS3Redirects(targetDir string) (map[string]string, error) {
+	redirects := make(map[string]string)
+
+	filename := filepath.Join(targetDir, uredirect.S3Filename)
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return redirects, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("error opening file '%s': %w", filename, err)
+	}
+	defer f.Close()
+
+	s3Redirects, err := uredirect.ReadS3(f)
+	if err != nil {
+		return nil, xerrors.Errorf("error reading redirects '%s': %w", filename, err)
+	}
+
+	for _, redirect := range s3Redirects {
+		redirects[redirect.From] = redirect.To
+	}
+
+	return redirects, nil
+}
+
+// showUploads prints the objects that `make deploy` uploads for the site in
+// targetDir.
+func showUploads(targetDir string, opts *uploadsOptions) error {
+	var deployed *umanifest.Manifest
+	if opts.Since != "" {
+		var err error
+		deployed, err = umanifest.Read(opts.Since)
+		if err != nil {
+			return err
+		}
+	}
+
+	uploads, err := listUploads(targetDir, deployed)
+	if err != nil {
+		return err
+	}
+
+	printUploads(os.Stdout, uploads)
+	return nil
+}